@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/pipeline"
+	"github.com/findcoo/s4/river"
+	"github.com/urfave/cli"
+)
+
+var (
+	// ErrPipelineNotFound the pipeline is not declared in the configuration file
+	ErrPipelineNotFound = errors.New("pipeline is not found in the configuration")
+
+	bufferPathFlag = []cli.Flag{
+		cli.StringFlag{
+			Name:   "buffer, b",
+			Value:  "./tmp",
+			Usage:  "path of the file buffer",
+			EnvVar: "S4_BUFFER_PATH",
+		},
+		cli.StringFlag{
+			Name:   "type, t",
+			Value:  "line",
+			Usage:  "define the buffer type that can be parsed format(json, line, memory)",
+			EnvVar: "S4_RIVER_TYPE",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "path of the configuration file, overrides the buffer and the type",
+			EnvVar: "S4_CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "pipeline, p",
+			Usage: "pipeline name of the configuration file",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print as json",
+		},
+		bufferKeyFlag,
+	}
+)
+
+// configRiver opens the river of the configured pipeline, the buffer replaces the buffer
+// of the pipeline unless it is empty
+func configRiver(c *cli.Context, buffer string) (river.River, error) {
+	conf, err := config.Load(c.String("config"))
+	if err != nil {
+		return nil, err
+	}
+	for _, pc := range conf.Pipelines {
+		if pc.Name == c.String("pipeline") {
+			if buffer != "" {
+				pc.River.Buffer = buffer
+			}
+			p, err := pipeline.New(pc)
+			if err != nil {
+				return nil, err
+			}
+			return p.River(), nil
+		}
+	}
+	return nil, ErrPipelineNotFound
+}
+
+// bufferRiver opens the buffer of a stopped river, the processors of the flags apply to the new records
+func bufferRiver(c *cli.Context) (river.River, error) {
+	if c.String("config") != "" {
+		return configRiver(c, "")
+	}
+
+	chain, _, err := pipeline.Processors(processOptions(c))
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := crypt.ParseKeyring(c.StringSlice("buffer-key"))
+	if err != nil {
+		return nil, err
+	}
+	riverConfig := &river.Config{
+		BufferPath: c.String("buffer"),
+		Processors: chain,
+		Keyring:    keyring,
+	}
+	if c.String("s3Path") != "" && c.String("region") != "" {
+		sink, err := s3Sink(c)
+		if err != nil {
+			return nil, err
+		}
+		s3sink, err := pipeline.NewSink(sink)
+		if err != nil {
+			return nil, err
+		}
+		riverConfig.Supplyer = &lake.SinkSupplyer{Sink: s3sink}
+	}
+	return river.NewRiver(c.String("type"), riverConfig)
+}
+
+func withBuffer(action func(*cli.Context, river.River) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		r, err := bufferRiver(c)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return action(c, r)
+	}
+}
+
+func bufferStat(c *cli.Context, r river.River) error {
+	stat, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(stat)
+	}
+	fmt.Println(stat)
+	return nil
+}
+
+func bufferDump(c *cli.Context, r river.River) error {
+	encoder := json.NewEncoder(os.Stdout)
+	return r.Walk(func(offset uint64, record []byte) error {
+		if !c.Bool("json") {
+			_, err := os.Stdout.Write(record)
+			return err
+		}
+		return encoder.Encode(map[string]interface{}{
+			"offset": offset,
+			"record": strings.TrimSuffix(string(record), "\n"),
+		})
+	})
+}
+
+func bufferFlush(c *cli.Context, r river.River) error {
+	if c.String("config") == "" && (c.String("s3Path") == "" || c.String("region") == "") {
+		return ErrOptionRequired
+	}
+
+	stat, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if err := r.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("flushed %s\n", stat)
+	return nil
+}
+
+func bufferPurge(c *cli.Context, r river.River) error {
+	stat, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	if err := r.Purge(); err != nil {
+		return err
+	}
+	fmt.Printf("purged %s\n", stat)
+	return nil
+}
+
+func bufferCommand() cli.Command {
+	return cli.Command{
+		Name:  "buffer",
+		Usage: "inspect the buffer of a stopped river",
+		Subcommands: []cli.Command{
+			{
+				Name:   "stat",
+				Flags:  bufferPathFlag,
+				Usage:  "print the record count, bytes and offsets of the buffer",
+				Action: withBuffer(bufferStat),
+			},
+			{
+				Name:   "dump",
+				Flags:  bufferPathFlag,
+				Usage:  "print the records of the buffer",
+				Action: withBuffer(bufferDump),
+			},
+			{
+				Name:   "flush",
+				Flags:  flags(bufferPathFlag, s3ConfigFlag),
+				Usage:  "push the buffer through the supplyer now",
+				Action: withBuffer(bufferFlush),
+			},
+			{
+				Name:   "purge",
+				Flags:  bufferPathFlag,
+				Usage:  "discard the buffer",
+				Action: withBuffer(bufferPurge),
+			},
+		},
+	}
+}
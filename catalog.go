@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+
+	"github.com/findcoo/s4/lake"
+	"github.com/urfave/cli"
+)
+
+var catalogConfigFlag = []cli.Flag{
+	cli.StringFlag{
+		Name:   "catalog",
+		Value:  "./s4.catalog",
+		Usage:  "path of the local catalog written by the s3 sink",
+		EnvVar: "S4_CATALOG",
+	},
+	cli.StringSliceFlag{
+		Name:  "table",
+		Usage: "table to export, all tables by default",
+	},
+}
+
+func s4CatalogExport(c *cli.Context) error {
+	catalog, err := lake.OpenCatalog(c.String("catalog"))
+	if err != nil {
+		return err
+	}
+	return catalog.Export(os.Stdout, c.StringSlice("table")...)
+}
+
+func catalogCommand() cli.Command {
+	return cli.Command{
+		Name:  "catalog",
+		Usage: "manage the local catalog of the partitions",
+		Subcommands: []cli.Command{
+			{
+				Name:   "export",
+				Flags:  catalogConfigFlag,
+				Usage:  "print the hive DDL and the partitions of the tables",
+				Action: s4CatalogExport,
+			},
+		},
+	}
+}
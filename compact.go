@@ -0,0 +1,129 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/logger"
+	"github.com/urfave/cli"
+)
+
+var compactConfigFlag = []cli.Flag{
+	cli.StringFlag{
+		Name:   "s3Path, s",
+		Usage:  "s3 path written by s4 to compact, required",
+		EnvVar: "S4_S3_PATH",
+	},
+	cli.StringFlag{
+		Name:   "region, r",
+		Usage:  "aws s3 region, required",
+		EnvVar: "S4_REGION",
+	},
+	cli.StringFlag{
+		Name:  "from",
+		Usage: "compact the partitions at or after the time(2006-01-02, 2006-01-02T15:04 or RFC3339)",
+	},
+	cli.StringFlag{
+		Name:  "to",
+		Usage: "compact the partitions before the time",
+	},
+	cli.Int64Flag{
+		Name:  "target-size",
+		Value: 128,
+		Usage: "megabytes of the compressed objects merged into an object",
+	},
+	cli.IntFlag{
+		Name:  "compression-level",
+		Usage: "gzip level of the merged objects from 1 to 9, the default level if zero, the records are not re-encoded otherwise",
+	},
+	cli.StringFlag{
+		Name:  "sse",
+		Usage: "server-side encryption of the merged objects(sse-s3, sse-kms)",
+	},
+	cli.StringFlag{
+		Name:  "sse-kms-key-id",
+		Usage: "kms key of the sse-kms encryption",
+	},
+	cli.StringFlag{
+		Name:  "storage-class",
+		Usage: "storage class of the merged objects",
+	},
+	cli.StringSliceFlag{
+		Name:  "envelope-key",
+		Usage: "master key decrypting the sources and encrypting the merged objects, \"id=file:/path\" or \"id=env:VARIABLE\"",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "print the objects to merge without writing",
+	},
+}
+
+func s4Compact(c *cli.Context) error {
+	s3Path, region := c.String("s3Path"), c.String("region")
+	if s3Path == "" || region == "" {
+		return ErrOptionRequired
+	}
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(c.String("to"))
+	if err != nil {
+		return err
+	}
+	keyring, err := crypt.ParseKeyring(c.StringSlice("envelope-key"))
+	if err != nil {
+		return err
+	}
+
+	bucket, key := path.Split(s3Path)
+	compactor, err := lake.OpenCompactor(region, strings.TrimRight(bucket, "/"), key, c.Int64("target-size")<<20)
+	if err != nil {
+		return err
+	}
+	compactor.Reader.Keyring = keyring
+	compactor.Writer.Options.Envelope = keyring
+	compactor.Writer.Options.Encryption = c.String("sse")
+	compactor.Writer.Options.KMSKeyID = c.String("sse-kms-key-id")
+	compactor.Writer.Options.StorageClass = c.String("storage-class")
+	compactor.Writer.Options.CompressionLevel = c.Int("compression-level")
+
+	if !c.Bool("dry-run") {
+		if err := compactor.Resume(); err != nil {
+			return err
+		}
+	}
+	groups, err := compactor.Plan(from, to)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		var size int64
+		for _, object := range group {
+			size += object.Size
+		}
+		if c.Bool("dry-run") {
+			logger.Printf("Merge %d objects of %d bytes in %s", len(group), size, path.Dir(group[0].Key))
+			continue
+		}
+
+		merged, err := compactor.Merge(group)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Merged %d objects of %d bytes into %s", len(group), size, merged)
+	}
+	logger.Printf("Compacted %d groups", len(groups))
+	return nil
+}
+
+func compactCommand() cli.Command {
+	return cli.Command{
+		Name:   "compact",
+		Flags:  compactConfigFlag,
+		Usage:  "merge the small objects of each partition into larger objects, only the gzip level is re-encoded",
+		Action: s4Compact,
+	}
+}
@@ -0,0 +1,347 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/process"
+	"github.com/findcoo/s4/river"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// input modes
+const (
+	ModeClient = "client"
+	ModeServer = "server"
+)
+
+// sink types
+const (
+	SinkS3      = "s3"
+	SinkConsole = "console"
+)
+
+var (
+	// ErrNoPipeline configuration without pipelines
+	ErrNoPipeline = errors.New("no pipeline is declared")
+	// ErrDuplicatedPipeline two pipelines share a name
+	ErrDuplicatedPipeline = errors.New("duplicated pipeline name")
+	// ErrDuplicatedBuffer two pipelines share a buffer path
+	ErrDuplicatedBuffer = errors.New("duplicated buffer path")
+	// ErrFieldRequired required field is empty
+	ErrFieldRequired = errors.New("required field is empty")
+	// ErrUnknownValue unknown mode, type or policy
+	ErrUnknownValue = errors.New("unknown value")
+	// ErrEventTimeWithoutJSON the event time partitioning is given to a river that is not json
+	ErrEventTimeWithoutJSON = errors.New("event time requires the json river")
+	// ErrNegativeValue negative count or duration
+	ErrNegativeValue = errors.New("negative value")
+	// ErrOutOfRange ratio outside of [0, 1]
+	ErrOutOfRange = errors.New("value out of range [0, 1]")
+	// ErrKMSKeyWithoutKMS the kms key is given without the sse-kms encryption
+	ErrKMSKeyWithoutKMS = errors.New("kms key requires the sse-kms encryption")
+
+	storageClasses = []string{"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER", "DEEP_ARCHIVE"}
+	cannedACLs     = []string{"private", "public-read", "public-read-write", "authenticated-read", "aws-exec-read", "bucket-owner-read", "bucket-owner-full-control"}
+)
+
+// Config s4 process configuration
+type Config struct {
+	Metrics   string     `yaml:"metrics"`
+	Pipelines []Pipeline `yaml:"pipelines"`
+}
+
+// Pipeline an input flows through a river to the sinks
+type Pipeline struct {
+	Name       string     `yaml:"name"`
+	Input      Input      `yaml:"input"`
+	River      River      `yaml:"river"`
+	Processors Processors `yaml:"processors"`
+	Upload     Upload     `yaml:"upload"`
+	Sinks      []Sink     `yaml:"sinks"`
+}
+
+// Upload pushes the batches on a pool of workers, zero concurrency pushes them in the consumer
+type Upload struct {
+	Concurrency int `yaml:"concurrency"`
+	// Queue batches waiting for the workers before the consumer blocks, the concurrency by default
+	Queue int `yaml:"queue"`
+	// Ordered pushes the batches of a partition one by one in order
+	Ordered bool `yaml:"ordered"`
+	// Timeout of each object uploaded by the s3 sinks, zero waits
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Input unix socket input
+type Input struct {
+	Mode   string `yaml:"mode"`
+	Socket string `yaml:"socket"`
+	// Reconnect dials the socket again with an exponential backoff when the connection ends in the client mode
+	Reconnect bool `yaml:"reconnect"`
+	// Retries failed dials in a row before the pipeline stops, zero retries forever
+	Retries    int           `yaml:"retries"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// River buffer of the pipeline
+type River struct {
+	Type        string        `yaml:"type"`
+	Buffer      string        `yaml:"buffer"`
+	Flush       time.Duration `yaml:"flush"`
+	MaxBuffer   int64         `yaml:"max_buffer"`
+	Overflow    string        `yaml:"overflow"`
+	SegmentSize int64         `yaml:"segment_size"`
+	SegmentAge  time.Duration `yaml:"segment_age"`
+	// Durability none, interval or always
+	Durability   string        `yaml:"durability"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	// Keys encrypt the buffer, "id=file:/path" or "id=env:VARIABLE", the first one encrypts
+	Keys []string `yaml:"keys"`
+}
+
+// Processors processors applied before the buffer
+type Processors struct {
+	Redact       []string `yaml:"redact"`
+	RedactFields []string `yaml:"redact_fields"`
+	Sample       float64  `yaml:"sample"`
+	SampleKey    string   `yaml:"sample_key"`
+	Rate         Rate     `yaml:"rate"`
+	ConnRate     Rate     `yaml:"conn_rate"`
+	// Stamp adds the ingest metadata to the records, fields for the json river, prefix or json for the others
+	Stamp string `yaml:"stamp"`
+}
+
+// Rate records and bytes per second, zero is unlimited
+type Rate struct {
+	Records float64 `yaml:"records"`
+	Bytes   float64 `yaml:"bytes"`
+	Policy  string  `yaml:"policy"`
+}
+
+// Sink data-lake of the pipeline
+type Sink struct {
+	Type   string `yaml:"type"`
+	S3Path string `yaml:"s3_path"`
+	Region string `yaml:"region"`
+	// Encryption sse-s3 or sse-kms
+	Encryption   string            `yaml:"encryption"`
+	KMSKeyID     string            `yaml:"kms_key_id"`
+	StorageClass string            `yaml:"storage_class"`
+	ACL          string            `yaml:"acl"`
+	Tags         map[string]string `yaml:"tags"`
+	Metadata     map[string]string `yaml:"metadata"`
+	// EnvelopeKeys master keys of the client-side encryption, "id=file:/path" or "id=env:VARIABLE"
+	EnvelopeKeys []string  `yaml:"envelope_keys"`
+	EventTime    EventTime `yaml:"event_time"`
+	// Idempotent names the objects by the hash of the records and skips the uploaded ones
+	Idempotent bool `yaml:"idempotent"`
+	// Manifest writes a manifest of the objects of each flush under <key>/_manifests/
+	Manifest bool `yaml:"manifest"`
+	// Catalog path of the local catalog of the partitions, Table name in it, the last element of the key by default
+	Catalog   string    `yaml:"catalog"`
+	Table     string    `yaml:"table"`
+	Retention Retention `yaml:"retention"`
+}
+
+// Retention deletes or transitions the objects of the s3 path older than the period
+type Retention struct {
+	Period time.Duration `yaml:"period"`
+	// StorageClass transitions the objects instead of deleting them
+	StorageClass string `yaml:"storage_class"`
+	// Interval of the retention while the pipeline runs, zero runs it only by the retention command
+	Interval time.Duration `yaml:"interval"`
+	DryRun   bool          `yaml:"dry_run"`
+}
+
+// EventTime partitions the records of the json river by a timestamp field
+type EventTime struct {
+	Field string `yaml:"field"`
+	// Format rfc3339, unix, unix_ms or a layout of the time package
+	Format string `yaml:"format"`
+	// Fallback prefix of the records without a valid timestamp, the upload time partition by default
+	Fallback string `yaml:"fallback"`
+}
+
+// Load reads and validates the configuration file
+func Load(path string) (*Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(raw, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate fills the defaults and checks up the pipelines
+func (c *Config) Validate() error {
+	if len(c.Pipelines) == 0 {
+		return ErrNoPipeline
+	}
+
+	names := make(map[string]bool)
+	buffers := make(map[string]bool)
+	for i := range c.Pipelines {
+		p := &c.Pipelines[i]
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Name, err)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("pipeline %q: %v", p.Name, ErrDuplicatedPipeline)
+		}
+		if p.River.Buffer != "" && buffers[p.River.Buffer] {
+			return fmt.Errorf("pipeline %q: %v", p.Name, ErrDuplicatedBuffer)
+		}
+		names[p.Name] = true
+		if p.River.Buffer != "" {
+			buffers[p.River.Buffer] = true
+		}
+	}
+	return nil
+}
+
+// Validate fills the defaults and checks up the pipeline
+func (p *Pipeline) Validate() error {
+	if p.Name == "" {
+		return required("name")
+	}
+
+	if p.Input.Mode == "" {
+		p.Input.Mode = ModeServer
+	}
+	if err := oneOf("input.mode", p.Input.Mode, ModeClient, ModeServer); err != nil {
+		return err
+	}
+	if p.Input.Socket == "" {
+		return required("input.socket")
+	}
+
+	if p.River.Type == "" {
+		p.River.Type = river.TypeLine
+	}
+	if err := oneOf("river.type", p.River.Type, river.TypeLine, river.TypeJSON, river.TypeMemory); err != nil {
+		return err
+	}
+	if p.River.Buffer == "" && p.River.Type != river.TypeMemory {
+		return required("river.buffer")
+	}
+	if p.River.Flush == 0 {
+		p.River.Flush = time.Minute * 5
+	}
+	if p.River.Overflow == "" && p.River.Type == river.TypeMemory {
+		p.River.Overflow = river.OverflowDropOldest
+	}
+	if p.River.Overflow == "" {
+		p.River.Overflow = river.OverflowBlock
+	}
+	if p.River.Durability == "" {
+		p.River.Durability = river.DurabilityNone
+	}
+	if err := oneOf("river.durability", p.River.Durability, river.DurabilityNone, river.DurabilityInterval, river.DurabilityAlways); err != nil {
+		return err
+	}
+	if err := oneOf("river.overflow", p.River.Overflow, river.OverflowBlock, river.OverflowDropNewest, river.OverflowDropOldest); err != nil {
+		return err
+	}
+
+	stamps := []string{"", process.StampPrefix, process.StampJSON}
+	if p.River.Type == river.TypeJSON {
+		stamps = []string{"", process.StampFields}
+	}
+	if err := oneOf("processors.stamp", p.Processors.Stamp, stamps...); err != nil {
+		return err
+	}
+
+	if p.Processors.Sample < 0 || p.Processors.Sample > 1 {
+		return fmt.Errorf("processors.sample: %v", ErrOutOfRange)
+	}
+	for _, rate := range []*Rate{&p.Processors.Rate, &p.Processors.ConnRate} {
+		if rate.Policy == "" {
+			rate.Policy = process.PolicyBlock
+		}
+		if err := oneOf("rate.policy", rate.Policy, process.PolicyBlock, process.PolicyDrop); err != nil {
+			return err
+		}
+	}
+
+	if p.Input.Retries < 0 || p.Input.Backoff < 0 || p.Input.MaxBackoff < 0 {
+		return fmt.Errorf("input: %v", ErrNegativeValue)
+	}
+	if p.Upload.Concurrency < 0 || p.Upload.Queue < 0 || p.Upload.Timeout < 0 {
+		return fmt.Errorf("upload: %v", ErrNegativeValue)
+	}
+	if p.Upload.Queue == 0 {
+		p.Upload.Queue = p.Upload.Concurrency
+	}
+
+	if len(p.Sinks) == 0 {
+		return required("sinks")
+	}
+	for _, sink := range p.Sinks {
+		if err := sink.Validate(); err != nil {
+			return err
+		}
+		if sink.EventTime.Field != "" && p.River.Type != river.TypeJSON {
+			return fmt.Errorf("sinks.event_time: %v", ErrEventTimeWithoutJSON)
+		}
+	}
+	return nil
+}
+
+// Validate checks up the sink
+func (s *Sink) Validate() error {
+	switch s.Type {
+	case SinkConsole:
+	case SinkS3:
+		if s.S3Path == "" {
+			return required("sinks.s3_path")
+		}
+		if s.Region == "" {
+			return required("sinks.region")
+		}
+		if err := oneOf("sinks.encryption", s.Encryption, "", lake.EncryptionS3, lake.EncryptionKMS); err != nil {
+			return err
+		}
+		if s.KMSKeyID != "" && s.Encryption != lake.EncryptionKMS {
+			return fmt.Errorf("sinks.kms_key_id: %v", ErrKMSKeyWithoutKMS)
+		}
+		if err := oneOf("sinks.storage_class", s.StorageClass, append([]string{""}, storageClasses...)...); err != nil {
+			return err
+		}
+		if err := oneOf("sinks.acl", s.ACL, append([]string{""}, cannedACLs...)...); err != nil {
+			return err
+		}
+		if err := oneOf("sinks.retention.storage_class", s.Retention.StorageClass, append([]string{""}, storageClasses...)...); err != nil {
+			return err
+		}
+		if s.Retention.Period <= 0 && (s.Retention.Interval > 0 || s.Retention.StorageClass != "") {
+			return required("sinks.retention.period")
+		}
+	default:
+		return fmt.Errorf("sinks.type %q: %v", s.Type, ErrUnknownValue)
+	}
+	return nil
+}
+
+func required(field string) error {
+	return fmt.Errorf("%s: %v", field, ErrFieldRequired)
+}
+
+func oneOf(field, value string, values ...string) error {
+	for _, v := range values {
+		if value == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %q: %v", field, value, ErrUnknownValue)
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+metrics: 127.0.0.1:9100
+pipelines:
+  - name: app
+    input:
+      socket: ./app.sock
+    river:
+      type: json
+      buffer: ./app.db
+    processors:
+      redact: [email]
+      rate:
+        records: 100
+    sinks:
+      - type: s3
+        s3_path: test.s4/app
+        region: ap-northeast-2
+  - name: debug
+    input:
+      mode: client
+      socket: ./debug.sock
+    river:
+      buffer: ./debug
+      flush: 10s
+    sinks:
+      - type: console
+`
+
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "s4-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Pipelines) != 2 {
+		t.Fatalf("unexpected pipelines: %d", len(config.Pipelines))
+	}
+
+	app, debug := config.Pipelines[0], config.Pipelines[1]
+	if app.Input.Mode != ModeServer || app.River.Flush != time.Minute*5 || app.Processors.Rate.Policy != "block" {
+		t.Fatalf("defaults are not filled: %+v", app)
+	}
+	if debug.River.Type != "line" || debug.River.Flush != time.Second*10 {
+		t.Fatalf("unexpected river: %+v", debug.River)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pipeline := Pipeline{
+		Name:  "app",
+		Input: Input{Socket: "./app.sock"},
+		River: River{Type: "xml", Buffer: "./app"},
+		Sinks: []Sink{{Type: SinkConsole}},
+	}
+	if err := pipeline.Validate(); err == nil {
+		t.Fatal("unknown river type must be rejected")
+	}
+
+	config := &Config{Pipelines: []Pipeline{
+		{Name: "a", Input: Input{Socket: "./a.sock"}, River: River{Buffer: "./tmp"}, Sinks: []Sink{{Type: SinkConsole}}},
+		{Name: "b", Input: Input{Socket: "./b.sock"}, River: River{Buffer: "./tmp"}, Sinks: []Sink{{Type: SinkConsole}}},
+	}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("shared buffer path must be rejected")
+	}
+
+	memory := Pipeline{
+		Name:  "memory",
+		Input: Input{Socket: "./memory.sock"},
+		River: River{Type: "memory"},
+		Sinks: []Sink{{Type: SinkConsole}},
+	}
+	if err := memory.Validate(); err != nil || memory.River.Overflow != "drop-oldest" {
+		t.Fatalf("memory river without buffer: %v %+v", err, memory.River)
+	}
+}
+
+func TestValidateSink(t *testing.T) {
+	sink := Sink{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", Encryption: "sse-kms", KMSKeyID: "alias/s4", StorageClass: "STANDARD_IA", ACL: "private"}
+	if err := sink.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []Sink{
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", Encryption: "sse-c"},
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", Encryption: "sse-s3", KMSKeyID: "alias/s4"},
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", StorageClass: "COLD"},
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", ACL: "everyone"},
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", Retention: Retention{StorageClass: "COLD", Period: time.Hour}},
+		{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", Retention: Retention{Interval: time.Hour}},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("invalid sink is accepted: %+v", invalid)
+		}
+	}
+}
+
+func TestValidateStamp(t *testing.T) {
+	pipeline := Pipeline{
+		Name:       "app",
+		Input:      Input{Socket: "./app.sock"},
+		River:      River{Type: "json", Buffer: "./app"},
+		Processors: Processors{Stamp: "prefix"},
+		Sinks:      []Sink{{Type: SinkConsole}},
+	}
+	if err := pipeline.Validate(); err == nil {
+		t.Fatal("prefix stamp of the json river must be rejected")
+	}
+	pipeline.Processors.Stamp = "fields"
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateSample(t *testing.T) {
+	pipeline := Pipeline{
+		Name:  "app",
+		Input: Input{Socket: "./app.sock"},
+		River: River{Buffer: "./app"},
+		Sinks: []Sink{{Type: SinkConsole}},
+	}
+	for _, sample := range []float64{0, 0.5, 1} {
+		pipeline.Processors.Sample = sample
+		if err := pipeline.Validate(); err != nil {
+			t.Fatalf("sample %v is rejected: %v", sample, err)
+		}
+	}
+	for _, sample := range []float64{-0.1, 1.5, 10} {
+		pipeline.Processors.Sample = sample
+		if err := pipeline.Validate(); err == nil {
+			t.Fatalf("sample %v out of range is accepted", sample)
+		}
+	}
+}
+
+func TestValidateEventTime(t *testing.T) {
+	pipeline := Pipeline{
+		Name:  "app",
+		Input: Input{Socket: "./app.sock"},
+		River: River{Type: "line", Buffer: "./app"},
+		Sinks: []Sink{{Type: SinkS3, S3Path: "bucket/prefix", Region: "ap-northeast-2", EventTime: EventTime{Field: "ts"}}},
+	}
+	if err := pipeline.Validate(); err == nil {
+		t.Fatal("event time of the line river must be rejected")
+	}
+	pipeline.River.Type = "json"
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateUpload(t *testing.T) {
+	pipeline := Pipeline{
+		Name:   "app",
+		Input:  Input{Socket: "./app.sock"},
+		River:  River{Type: "line", Buffer: "./app"},
+		Upload: Upload{Concurrency: 4},
+		Sinks:  []Sink{{Type: SinkConsole}},
+	}
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if pipeline.Upload.Queue != 4 {
+		t.Fatalf("unexpected default queue: %d", pipeline.Upload.Queue)
+	}
+	pipeline.Upload.Timeout = -time.Second
+	if err := pipeline.Validate(); err == nil {
+		t.Fatal("negative timeout must be rejected")
+	}
+}
+
+func TestValidateReconnect(t *testing.T) {
+	pipeline := Pipeline{
+		Name:  "app",
+		Input: Input{Socket: "./app.sock", Reconnect: true, Backoff: time.Second},
+		River: River{Type: "line", Buffer: "./app"},
+		Sinks: []Sink{{Type: SinkConsole}},
+	}
+	if err := pipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pipeline.Input.Retries = -1
+	if err := pipeline.Validate(); err == nil {
+		t.Fatal("negative retries must be rejected")
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff differences of the pipelines between two configurations
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed map[string][]string
+}
+
+// Compare compares the pipelines of the configurations by name
+func Compare(old, new *Config) *Diff {
+	diff := &Diff{
+		Changed: make(map[string][]string),
+	}
+
+	olds := make(map[string]Pipeline)
+	for _, p := range old.Pipelines {
+		olds[p.Name] = p
+	}
+	news := make(map[string]bool)
+	for _, p := range new.Pipelines {
+		news[p.Name] = true
+		o, ok := olds[p.Name]
+		if !ok {
+			diff.Added = append(diff.Added, p.Name)
+			continue
+		}
+		if sections := changedSections(o, p); len(sections) > 0 {
+			diff.Changed[p.Name] = sections
+		}
+	}
+	for _, p := range old.Pipelines {
+		if !news[p.Name] {
+			diff.Removed = append(diff.Removed, p.Name)
+		}
+	}
+	return diff
+}
+
+func changedSections(old, new Pipeline) []string {
+	var sections []string
+	o, n := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			sections = append(sections, o.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return sections
+}
+
+// Empty reports whether nothing changed
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String formats the diff
+func (d *Diff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	changed := make([]string, 0, len(d.Changed))
+	for name, sections := range d.Changed {
+		changed = append(changed, fmt.Sprintf("%s(%s)", name, strings.Join(sections, ",")))
+	}
+	sort.Strings(changed)
+
+	return fmt.Sprintf("added: [%s] removed: [%s] changed: [%s]",
+		strings.Join(d.Added, " "), strings.Join(d.Removed, " "), strings.Join(changed, " "))
+}
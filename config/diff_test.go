@@ -0,0 +1,36 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	sink := []Sink{{Type: SinkConsole}}
+	old := &Config{Pipelines: []Pipeline{
+		{Name: "kept", River: River{Buffer: "./kept"}, Sinks: sink},
+		{Name: "changed", River: River{Buffer: "./changed"}, Sinks: sink},
+		{Name: "removed", River: River{Buffer: "./removed"}, Sinks: sink},
+	}}
+	new := &Config{Pipelines: []Pipeline{
+		{Name: "kept", River: River{Buffer: "./kept"}, Sinks: sink},
+		{Name: "changed", River: River{Buffer: "./changed"}, Processors: Processors{Redact: []string{"email"}}, Sinks: sink},
+		{Name: "added", River: River{Buffer: "./added"}, Sinks: sink},
+	}}
+
+	diff := Compare(old, new)
+	if len(diff.Added) != 1 || diff.Added[0] != "added" {
+		t.Fatalf("unexpected added: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "removed" {
+		t.Fatalf("unexpected removed: %v", diff.Removed)
+	}
+	sections, ok := diff.Changed["changed"]
+	if len(diff.Changed) != 1 || !ok || sections[0] != "processors" {
+		t.Fatalf("unexpected changed: %v", diff.Changed)
+	}
+	t.Log(diff)
+
+	if !Compare(old, old).Empty() {
+		t.Fatal("same configuration must be empty")
+	}
+}
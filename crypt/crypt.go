@@ -0,0 +1,217 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// sources of a key
+const (
+	SourceFile = "file"
+	SourceEnv  = "env"
+)
+
+var (
+	// ErrMalformedKey malformed key definition or key material that is not an AES key
+	ErrMalformedKey = errors.New("malformed encryption key")
+	// ErrUnknownKey the record is encrypted with a key that is not in the keyring
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrNoKeyring the record is encrypted but no keyring is given
+	ErrNoKeyring = errors.New("encrypted record without keyring")
+	// ErrMalformedRecord the encrypted record is truncated
+	ErrMalformedRecord = errors.New("malformed encrypted record")
+
+	// magic prefixes the encrypted records, plain text and json never start with a NUL
+	magic = []byte("\x00s4e")
+)
+
+// Key an AES key named by its id
+type Key struct {
+	ID     string
+	Source string
+	Name   string
+	Secret []byte
+}
+
+// ParseKey parses "id=file:/path/of/key" or "id=env:VARIABLE",
+// the key material is a hex or base64 encoded AES-128, AES-192 or AES-256 key
+func ParseKey(definition string) (Key, error) {
+	i := strings.Index(definition, "=")
+	j := strings.Index(definition, ":")
+	if i <= 0 || j < i {
+		return Key{}, ErrMalformedKey
+	}
+	key := Key{
+		ID:     definition[:i],
+		Source: definition[i+1 : j],
+		Name:   definition[j+1:],
+	}
+	if len(key.ID) > 255 || key.Name == "" {
+		return Key{}, ErrMalformedKey
+	}
+
+	var material string
+	switch key.Source {
+	case SourceFile:
+		raw, err := ioutil.ReadFile(key.Name)
+		if err != nil {
+			return Key{}, err
+		}
+		material = string(raw)
+	case SourceEnv:
+		material = os.Getenv(key.Name)
+	default:
+		return Key{}, ErrMalformedKey
+	}
+
+	secret, err := decodeSecret(strings.TrimSpace(material))
+	if err != nil {
+		return Key{}, err
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+func decodeSecret(material string) ([]byte, error) {
+	if secret, err := hex.DecodeString(material); err == nil && validSize(len(secret)) {
+		return secret, nil
+	}
+	if secret, err := base64.StdEncoding.DecodeString(material); err == nil && validSize(len(secret)) {
+		return secret, nil
+	}
+	return nil, ErrMalformedKey
+}
+
+func validSize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// Keyring AES-GCM ciphers by the key id, the primary key seals and all keys open
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring of the keys, the first key is the primary
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrMalformedKey
+	}
+
+	kr := &Keyring{
+		primary: keys[0].ID,
+		aeads:   make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.aeads[key.ID] = aead
+	}
+	return kr, nil
+}
+
+// ParseKeyring parses the key definitions into a Keyring, it returns nil without definitions
+func ParseKeyring(definitions []string) (*Keyring, error) {
+	if len(definitions) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]Key, len(definitions))
+	for i, definition := range definitions {
+		key, err := ParseKey(definition)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return NewKeyring(keys...)
+}
+
+// Primary returns the id of the key that seals
+func (kr *Keyring) Primary() string {
+	return kr.primary
+}
+
+// Seal encrypts the record with the primary key and tags it with the key id,
+// a nil Keyring returns the record as it is
+// format: magic | len(id) | id | nonce | ciphertext
+func (kr *Keyring) Seal(record []byte) ([]byte, error) {
+	if kr == nil {
+		return record, nil
+	}
+
+	id := []byte(kr.primary)
+	aead := kr.aeads[kr.primary]
+	header := make([]byte, 0, len(magic)+1+len(id)+aead.NonceSize())
+	header = append(header, magic...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, record, id), nil
+}
+
+// Open decrypts the record with the key of its id, the plain record is returned as it is
+func (kr *Keyring) Open(sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, magic) {
+		return sealed, nil
+	}
+	aead, id, rest, err := kr.cipher(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], id)
+}
+
+// Check reports whether the keyring holds the key of the record without decrypting it,
+// a plain record passes
+func (kr *Keyring) Check(sealed []byte) error {
+	if !bytes.HasPrefix(sealed, magic) {
+		return nil
+	}
+	_, _, _, err := kr.cipher(sealed)
+	return err
+}
+
+// cipher parses the header of the sealed record, it returns the cipher of its key id,
+// the id and the nonce followed by the ciphertext
+func (kr *Keyring) cipher(sealed []byte) (cipher.AEAD, []byte, []byte, error) {
+	if kr == nil {
+		return nil, nil, nil, ErrNoKeyring
+	}
+
+	rest := sealed[len(magic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return nil, nil, nil, ErrMalformedRecord
+	}
+	id := rest[1 : 1+int(rest[0])]
+	rest = rest[1+len(id):]
+
+	aead, ok := kr.aeads[string(id)]
+	if !ok {
+		return nil, nil, nil, ErrUnknownKey
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, nil, nil, ErrMalformedRecord
+	}
+	return aead, id, rest, nil
+}
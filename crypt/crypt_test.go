@@ -0,0 +1,92 @@
+package crypt
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const (
+	oldSecret = "000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f"
+	newSecret = "AAECAwQFBgcICQoLDA0ODw=="
+)
+
+func TestParseKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "s4-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString(oldSecret + "\n")
+	_ = f.Close()
+
+	key, err := ParseKey("old=file:" + f.Name())
+	if err != nil || key.ID != "old" || len(key.Secret) != 32 {
+		t.Fatalf("unexpected key %+v: %v", key, err)
+	}
+
+	os.Setenv("S4_TEST_KEY", newSecret)
+	defer os.Unsetenv("S4_TEST_KEY")
+	if key, err := ParseKey("new=env:S4_TEST_KEY"); err != nil || len(key.Secret) != 16 {
+		t.Fatalf("unexpected key %+v: %v", key, err)
+	}
+
+	for _, definition := range []string{"file:/tmp/key", "k=vault:secret", "k=env:S4_MISSING_KEY"} {
+		if _, err := ParseKey(definition); err == nil {
+			t.Fatalf("malformed key is accepted: %s", definition)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	os.Setenv("S4_OLD_KEY", oldSecret)
+	os.Setenv("S4_NEW_KEY", newSecret)
+	defer os.Unsetenv("S4_OLD_KEY")
+	defer os.Unsetenv("S4_NEW_KEY")
+
+	old, err := ParseKeyring([]string{"old=env:S4_OLD_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := []byte(`{"message": "secret"}` + "\n")
+	sealed, err := old.Seal(record)
+	if err != nil || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("record is not sealed: %q %v", sealed, err)
+	}
+
+	rotated, err := ParseKeyring([]string{"new=env:S4_NEW_KEY", "old=env:S4_OLD_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := rotated.Open(sealed); err != nil || !bytes.Equal(opened, record) {
+		t.Fatalf("old record is not opened after the rotation: %q %v", opened, err)
+	}
+	if plain, err := rotated.Open(record); err != nil || !bytes.Equal(plain, record) {
+		t.Fatalf("plain record is changed: %q %v", plain, err)
+	}
+
+	resealed, _ := rotated.Seal(record)
+	if _, err := old.Open(resealed); err != ErrUnknownKey {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := (*Keyring)(nil).Open(resealed); err != ErrNoKeyring {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := old.Check(resealed); err != ErrUnknownKey {
+		t.Fatalf("unexpected check: %v", err)
+	}
+	if err := (*Keyring)(nil).Check(resealed); err != ErrNoKeyring {
+		t.Fatalf("unexpected check: %v", err)
+	}
+	if err := rotated.Check(resealed); err != nil {
+		t.Fatalf("unexpected check: %v", err)
+	}
+	if err := (*Keyring)(nil).Check(record); err != nil {
+		t.Fatalf("plain record fails the check: %v", err)
+	}
+	resealed[len(resealed)-1] ^= 0xff
+	if _, err := rotated.Open(resealed); err == nil {
+		t.Fatal("tampered record is opened")
+	}
+}
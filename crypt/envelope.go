@@ -0,0 +1,128 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+const dataKeySize = 32
+
+// envelopeMagic prefixes the marshaled envelopes
+var envelopeMagic = []byte("\x00s4v")
+
+// Envelope a payload encrypted with a data key of its own, the data key is wrapped by the master key
+type Envelope struct {
+	WrappedKey []byte
+	// Ciphertext nonce | sealed payload
+	Ciphertext []byte
+}
+
+func dataCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealEnvelope encrypts the payload with a new data key wrapped by the primary key
+func (kr *Keyring) SealEnvelope(payload []byte) (*Envelope, error) {
+	if kr == nil {
+		return nil, ErrNoKeyring
+	}
+
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	aead, err := dataCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := kr.Seal(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		WrappedKey: wrapped,
+		Ciphertext: aead.Seal(nonce, nonce, payload, nil),
+	}, nil
+}
+
+// OpenEnvelope unwraps the data key with the master key of its id and decrypts the payload
+func (kr *Keyring) OpenEnvelope(e *Envelope) ([]byte, error) {
+	if kr == nil {
+		return nil, ErrNoKeyring
+	}
+	if !bytes.HasPrefix(e.WrappedKey, magic) {
+		return nil, ErrMalformedRecord
+	}
+
+	key, err := kr.Open(e.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := dataCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformedRecord
+	}
+	return aead.Open(nil, e.Ciphertext[:aead.NonceSize()], e.Ciphertext[aead.NonceSize():], nil)
+}
+
+// Marshal frames the envelope for the sinks without metadata, the frames can be concatenated
+// format: magic | len(wrapped key) uint16 | len(ciphertext) uint32 | wrapped key | ciphertext
+func (e *Envelope) Marshal() []byte {
+	header := make([]byte, len(envelopeMagic)+6)
+	copy(header, envelopeMagic)
+	binary.BigEndian.PutUint16(header[len(envelopeMagic):], uint16(len(e.WrappedKey)))
+	binary.BigEndian.PutUint32(header[len(envelopeMagic)+2:], uint32(len(e.Ciphertext)))
+
+	raw := append(header, e.WrappedKey...)
+	return append(raw, e.Ciphertext...)
+}
+
+// ReadEnvelope reads a marshaled envelope, it returns io.EOF after the last envelope
+func ReadEnvelope(r io.Reader) (*Envelope, error) {
+	header := make([]byte, len(envelopeMagic)+6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, ErrMalformedRecord
+	}
+	if !bytes.HasPrefix(header, envelopeMagic) {
+		return nil, ErrMalformedRecord
+	}
+
+	wrapped, err := readFrame(r, int64(binary.BigEndian.Uint16(header[len(envelopeMagic):])))
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := readFrame(r, int64(binary.BigEndian.Uint32(header[len(envelopeMagic)+2:])))
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// readFrame reads n bytes of a frame, the buffer grows with the bytes read
+// so that a corrupted length does not allocate more than the reader holds
+func readFrame(r io.Reader, n int64) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if read, _ := io.CopyN(buf, r, n); read != n {
+		return nil, ErrMalformedRecord
+	}
+	return buf.Bytes(), nil
+}
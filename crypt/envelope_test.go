@@ -0,0 +1,59 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	os.Setenv("S4_MASTER_KEY", oldSecret)
+	defer os.Unsetenv("S4_MASTER_KEY")
+	master, err := ParseKeyring([]string{"master=env:S4_MASTER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte("compressed payload")
+	first, err := master.SealEnvelope(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := master.SealEnvelope(payload)
+	if bytes.Equal(first.WrappedKey, second.WrappedKey) || bytes.Equal(first.Ciphertext, second.Ciphertext) {
+		t.Fatal("data key is shared by the objects")
+	}
+
+	frames := bytes.NewReader(append(first.Marshal(), second.Marshal()...))
+	envelope, err := ReadEnvelope(frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEnvelope(frames); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadEnvelope(frames); err != io.EOF {
+		t.Fatalf("unexpected error after the last envelope: %v", err)
+	}
+	if opened, err := master.OpenEnvelope(envelope); err != nil || !bytes.Equal(opened, payload) {
+		t.Fatalf("unexpected payload %q: %v", opened, err)
+	}
+
+	os.Setenv("S4_OTHER_KEY", newSecret)
+	defer os.Unsetenv("S4_OTHER_KEY")
+	other, _ := ParseKeyring([]string{"master=env:S4_OTHER_KEY"})
+	if _, err := other.OpenEnvelope(envelope); err == nil {
+		t.Fatal("envelope is opened with another master key")
+	}
+	if _, err := ReadEnvelope(bytes.NewReader(payload)); err != ErrMalformedRecord {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	torn := first.Marshal()
+	binary.BigEndian.PutUint32(torn[len(envelopeMagic)+2:], 0xffffffff)
+	if _, err := ReadEnvelope(bytes.NewReader(torn)); err != ErrMalformedRecord {
+		t.Fatalf("unexpected error of a length beyond the frame: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+	"github.com/urfave/cli"
+)
+
+var decryptConfigFlag = []cli.Flag{
+	cli.StringFlag{
+		Name:  "object",
+		Usage: "s3 path of an object encrypted by the s3 sink, \"bucket/key\"",
+	},
+	cli.StringFlag{
+		Name:  "region, r",
+		Usage: "aws s3 region of the object",
+	},
+	cli.StringFlag{
+		Name:  "file",
+		Usage: "file of the envelopes written by the console sink",
+	},
+	cli.StringFlag{
+		Name:  "output, o",
+		Usage: "path of the decrypted output, stdout by default",
+	},
+	envelopeKeyFlag,
+}
+
+// gunzip decompresses the data that is gzipped
+func gunzip(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, nil
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	return ioutil.ReadAll(gzr)
+}
+
+// decryptFile opens the concatenated envelopes of the file
+func decryptFile(keyring *crypt.Keyring, name string, w io.Writer) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		envelope, err := crypt.ReadEnvelope(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := keyring.OpenEnvelope(envelope)
+		if err != nil {
+			return err
+		}
+		if data, err = gunzip(data); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+}
+
+func s4Decrypt(c *cli.Context) error {
+	keyring, err := crypt.ParseKeyring(c.StringSlice("envelope-key"))
+	if err != nil {
+		return err
+	}
+	if keyring == nil || (c.String("object") == "") == (c.String("file") == "") {
+		return ErrOptionRequired
+	}
+
+	var w io.Writer = os.Stdout
+	if output := c.String("output"); output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if c.String("file") != "" {
+		return decryptFile(keyring, c.String("file"), w)
+	}
+
+	if c.String("region") == "" {
+		return ErrOptionRequired
+	}
+	object := strings.TrimLeft(c.String("object"), "/")
+	i := strings.Index(object, "/")
+	if i <= 0 {
+		return ErrOptionRequired
+	}
+	reader, err := lake.OpenS3Reader(c.String("region"), object[:i], "")
+	if err != nil {
+		return err
+	}
+	reader.Keyring = keyring
+	data, err := reader.Read(object[i+1:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func decryptCommand() cli.Command {
+	return cli.Command{
+		Name:   "decrypt",
+		Flags:  decryptConfigFlag,
+		Usage:  "decrypt the objects encrypted on the client side by the envelope key",
+		Action: s4Decrypt,
+	}
+}
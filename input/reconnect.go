@@ -0,0 +1,93 @@
+package input
+
+import (
+	"errors"
+	"time"
+
+	"github.com/findcoo/s4/logger"
+)
+
+const (
+	defaultBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	defaultStable     = 10 * time.Second
+)
+
+var (
+	// ErrRetriesExceeded the dials failed more than the retries in a row
+	ErrRetriesExceeded = errors.New("the retries of the connection are exceeded")
+)
+
+// Backoff delays of the reconnection, the delay doubles after each failed dial up to the Max
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Retries failed dials in a row before giving up, zero retries forever
+	Retries int
+	// Stable lifetime of a connection that resets the backoff, a shorter connection counts as a failure
+	Stable time.Duration
+}
+
+// Delay returns the delay before the next dial after the failures in a row
+func (b Backoff) Delay(failures int) time.Duration {
+	delay, max := b.Initial, b.Max
+	if delay <= 0 {
+		delay = defaultBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// stable returns the lifetime of a connection that resets the backoff
+func (b Backoff) stable() time.Duration {
+	if b.Stable <= 0 {
+		return defaultStable
+	}
+	return b.Stable
+}
+
+// Reconnect dials until the stop is closed, again with the backoff whenever the connection ends.
+// it returns ErrRetriesExceeded when the dials fail or the connections end early more than the retries in a row
+func Reconnect(log *logger.Entry, b Backoff, dial func() (*UnixSocket, error), stop <-chan struct{}) error {
+	var failures int
+	for {
+		us, err := dial()
+		if err != nil {
+			failures++
+			if b.Retries > 0 && failures > b.Retries {
+				return ErrRetriesExceeded
+			}
+			log.Warnf("Dial the waterhead: %v, retry in %s", err, b.Delay(failures))
+		} else {
+			connected := time.Now()
+			select {
+			case <-us.Done():
+			case <-stop:
+				us.Cancel()
+				return nil
+			}
+
+			// a producer accepting and closing at once is backed off like a failed dial
+			if time.Since(connected) >= b.stable() {
+				failures = 0
+			} else if failures++; b.Retries > 0 && failures > b.Retries {
+				return ErrRetriesExceeded
+			}
+			log.With("conn", us.ID).Warnf("The waterhead closed the connection, reconnect in %s", b.Delay(failures))
+		}
+
+		select {
+		case <-time.After(b.Delay(failures)):
+		case <-stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package input
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second}
+	expected := []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for failures, delay := range expected {
+		if d := b.Delay(failures); d != delay {
+			t.Fatalf("unexpected delay after %d failures: %s", failures, d)
+		}
+	}
+	if d := (Backoff{}).Delay(1); d != defaultBackoff {
+		t.Fatalf("unexpected default delay: %s", d)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	sockPath := "./reconnect.sock"
+	defer os.Remove(sockPath)
+	sock, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	// the producer closes the first connections as a restart does
+	go func() {
+		for i := 0; i < 2; i++ {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	dials := make(chan *UnixSocket, 3)
+	stop := make(chan struct{})
+	dial := func() (*UnixSocket, error) {
+		us, err := DialUnixSocket(sockPath)
+		if err == nil {
+			us.Publish()
+			dials <- us
+		}
+		return us, err
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- Reconnect(nil, Backoff{Initial: time.Millisecond}, dial, stop)
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-dials:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("dial %d is not made", i+1)
+		}
+	}
+	close(stop)
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconnectRetries(t *testing.T) {
+	var dials int
+	dial := func() (*UnixSocket, error) {
+		dials++
+		return DialUnixSocket("./absent.sock")
+	}
+	err := Reconnect(nil, Backoff{Initial: time.Millisecond, Retries: 3}, dial, make(chan struct{}))
+	if err != ErrRetriesExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dials != 4 {
+		t.Fatalf("unexpected dials: %d", dials)
+	}
+}
+
+func TestReconnectClosedAtOnce(t *testing.T) {
+	sockPath := "./closed.sock"
+	defer os.Remove(sockPath)
+	sock, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	// the producer accepts and closes every connection
+	go func() {
+		for {
+			c, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			_ = c.Close()
+		}
+	}()
+
+	var dials int
+	dial := func() (*UnixSocket, error) {
+		us, err := DialUnixSocket(sockPath)
+		if err == nil {
+			dials++
+			us.Publish()
+		}
+		return us, err
+	}
+	err = Reconnect(nil, Backoff{Initial: time.Millisecond, Retries: 2, Stable: time.Minute}, dial, make(chan struct{}))
+	if err != ErrRetriesExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dials != 3 {
+		t.Fatalf("unexpected dials: %d", dials)
+	}
+}
@@ -5,34 +5,61 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 
+	"github.com/findcoo/s4/logger"
 	"github.com/findcoo/stream"
 )
 
+var connID uint64
+
 // UnixSocket read data from unix socket
 type UnixSocket struct {
+	// ID of the connection, unique in the process
+	ID   uint64
 	conn net.Conn
+	log  *logger.Entry
+	once *sync.Once
+	done chan struct{}
 	*stream.BytesStream
 }
 
-// ConnectUnixSocket connect unix socket
-func ConnectUnixSocket(sockPath string) *UnixSocket {
-	c, err := net.Dial("unix", sockPath)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func newUnixSocket(sockPath string, c net.Conn) *UnixSocket {
 	obv := stream.NewObserver(stream.DefaultObservHandler())
-	bytesStream := stream.NewBytesStream(obv)
 	us := &UnixSocket{
+		ID:          atomic.AddUint64(&connID, 1),
 		conn:        c,
-		BytesStream: bytesStream,
+		once:        &sync.Once{},
+		done:        make(chan struct{}),
+		BytesStream: stream.NewBytesStream(obv),
 	}
-	obv.Handler.AtCancel = us.shutdown
+	us.log = logger.With("input", sockPath, "conn", us.ID)
 	obv.Handler.AtComplete = us.shutdown
 	return us
 }
 
+// ConnectUnixSocket connect unix socket, it exits the process on a failure
+func ConnectUnixSocket(sockPath string) *UnixSocket {
+	us, err := DialUnixSocket(sockPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return us
+}
+
+// DialUnixSocket connects the unix socket
+func DialUnixSocket(sockPath string) (*UnixSocket, error) {
+	c, err := net.Dial("unix", sockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	us := newUnixSocket(sockPath, c)
+	us.Handler.AtCancel = us.shutdown
+	return us, nil
+}
+
 func acceptAfter(sock net.Listener) <-chan net.Conn {
 	pipe := make(chan net.Conn, 1)
 	go func() {
@@ -45,44 +72,98 @@ func acceptAfter(sock net.Listener) <-chan net.Conn {
 	return pipe
 }
 
-// ListenUnixSocket returns a UnixSocket channel
+// ListenUnixSocket returns a UnixSocket channel, it exits the process on a failure
 func ListenUnixSocket(sockPath string) (<-chan *UnixSocket, func()) {
+	streams, stop, err := ServeUnixSocket(sockPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return streams, stop
+}
+
+// ServeUnixSocket listens the unix socket, it returns the channel of the accepted connections
+// and the function closing the socket, the socket is closed when it returns.
+// The stop closes the read side of the accepted connections, their streams end after
+// the records already sent by the clients are read, the channel is closed after the stop
+func ServeUnixSocket(sockPath string) (<-chan *UnixSocket, func(), error) {
+	sock, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	streams := make(chan *UnixSocket, 1)
-	done := make(chan struct{}, 1)
+	done := make(chan struct{})
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	mutex := &sync.Mutex{}
+	accepted := make(map[*UnixSocket]bool)
 	stop := func() {
-		done <- struct{}{}
-	}
+		once.Do(func() {
+			close(done)
+		})
+		<-closed
 
-	go func() {
-		sock, err := net.Listen("unix", sockPath)
-		if err != nil {
-			log.Fatal(err)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for us := range accepted {
+			us.drain()
 		}
+	}
 
-	ServerLoop:
+	go func() {
+		defer close(closed)
+		defer close(streams)
+		defer sock.Close()
 		for {
 			select {
 			case <-done:
-				_ = sock.Close()
-				break ServerLoop
+				return
 			case fd := <-acceptAfter(sock):
-				log.Print("accept client")
-				obv := stream.NewObserver(stream.DefaultObservHandler())
-				bytesStream := stream.NewBytesStream(obv)
-				us := &UnixSocket{
-					conn:        fd,
-					BytesStream: bytesStream,
+				us := newUnixSocket(sockPath, fd)
+				us.log.Infof("Accept the client")
+				select {
+				case streams <- us:
+				case <-done:
+					_ = fd.Close()
+					return
 				}
-				obv.Handler.AtComplete = us.shutdown
-				streams <- us
+
+				mutex.Lock()
+				accepted[us] = true
+				mutex.Unlock()
+				go func() {
+					<-us.Done()
+					mutex.Lock()
+					delete(accepted, us)
+					mutex.Unlock()
+				}()
 			}
 		}
 	}()
-	return streams, stop
+	return streams, stop, nil
+}
+
+// drain closes the read side of the connection, the records already sent by the client
+// are still read before the stream ends
+func (us *UnixSocket) drain() {
+	if uc, ok := us.conn.(*net.UnixConn); ok && uc.CloseRead() == nil {
+		us.log.Debugf("Drain the connection")
+		return
+	}
+	us.shutdown()
 }
 
 func (us *UnixSocket) shutdown() {
-	_ = us.conn.Close()
+	us.once.Do(func() {
+		us.log.Debugf("Close the connection")
+		_ = us.conn.Close()
+		close(us.done)
+	})
+}
+
+// Done returns a channel closed when the connection ends by the peer or the cancel
+func (us *UnixSocket) Done() <-chan struct{} {
+	return us.done
 }
 
 // Publish observ and publish the stream that read from the unix socket
@@ -109,7 +190,7 @@ func (us *UnixSocket) Publish() *UnixSocket {
 				us.OnComplete()
 				return
 			}
-			log.Fatal(err)
+			us.log.Errorf("Read the connection: %v", err)
 		}
 		us.OnComplete()
 
@@ -3,6 +3,7 @@ package input
 import (
 	"bytes"
 	"log"
+	"net"
 	"testing"
 
 	"github.com/findcoo/s4/test"
@@ -37,6 +38,35 @@ func TestListen(t *testing.T) {
 	stop()
 }
 
+func TestListenDrain(t *testing.T) {
+	sockPath := "./drain.sock"
+	streams, stop, err := ServeUnixSocket(sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("first\nsecond\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	us := <-streams
+	stop()
+	var lines int
+	us.Publish().Subscribe(func(data []byte) {
+		lines++
+	})
+	if lines != 2 {
+		t.Fatalf("records of the stopped connection are lost: %d", lines)
+	}
+	if _, ok := <-streams; ok {
+		t.Fatal("the channel of the connections is not closed")
+	}
+}
+
 func BenchmarkUnix(b *testing.B) {
 	iterN := 100
 	ready, _ := test.UnixBenchmarkServer(iterN, "./bench.sock")
@@ -0,0 +1,198 @@
+package lake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrTableNotFound the table is not in the catalog
+	ErrTableNotFound = errors.New("table is not found in the catalog")
+
+	hivePartitionPattern = regexp.MustCompile(`(year=\d{4}/month=\d{1,2}/day=\d{1,2})/`)
+	tableNamePattern     = regexp.MustCompile(`[^a-z0-9_]`)
+
+	catalogs = struct {
+		mutex  *sync.Mutex
+		opened map[string]*Catalog
+	}{&sync.Mutex{}, make(map[string]*Catalog)}
+)
+
+// table formats of the Catalog
+const (
+	TableJSON = "json"
+	TableLine = "line"
+)
+
+// Catalog the tables and the partitions written by the S3Supplyers, kept in a local json file
+type Catalog struct {
+	path   string
+	mutex  *sync.Mutex
+	Tables map[string]*Table `json:"tables"`
+}
+
+// Table an external table of the objects under a location
+type Table struct {
+	Location string `json:"location"`
+	Format   string `json:"format"`
+	// Columns hive types of the json fields
+	Columns map[string]string `json:"columns,omitempty"`
+	// Partitions "year=2017/month=8/day=3" in order
+	Partitions []string `json:"partitions"`
+}
+
+// TableName returns the default table name of the key
+func TableName(key string) string {
+	return tableNamePattern.ReplaceAllString(strings.ToLower(path.Base(key)), "_")
+}
+
+// OpenCatalog reads the catalog file, the S3Supplyers of the same file share the Catalog
+func OpenCatalog(name string) (*Catalog, error) {
+	catalogs.mutex.Lock()
+	defer catalogs.mutex.Unlock()
+
+	name = path.Clean(name)
+	if c, ok := catalogs.opened[name]; ok {
+		return c, nil
+	}
+
+	c := &Catalog{
+		path:   name,
+		mutex:  &sync.Mutex{},
+		Tables: make(map[string]*Table),
+	}
+	raw, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, c); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	catalogs.opened[name] = c
+	return c, nil
+}
+
+// Add adds the partitions and the columns of the manifest entries to the table
+func (c *Catalog) Add(name, location string, entries []ManifestEntry) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	table, ok := c.Tables[name]
+	if !ok {
+		table = &Table{Location: location, Format: TableLine}
+		c.Tables[name] = table
+	}
+
+	partitions := make(map[string]bool)
+	for _, p := range table.Partitions {
+		partitions[p] = true
+	}
+	for _, entry := range entries {
+		if match := hivePartitionPattern.FindStringSubmatch(entry.Key); match != nil {
+			partitions[match[1]] = true
+		}
+		if len(entry.Columns) > 0 {
+			table.Format = TableJSON
+			if table.Columns == nil {
+				table.Columns = make(map[string]string)
+			}
+		}
+		for column, t := range entry.Columns {
+			table.Columns[column] = widen(table.Columns[column], t)
+		}
+	}
+
+	table.Partitions = table.Partitions[:0]
+	for p := range partitions {
+		table.Partitions = append(table.Partitions, p)
+	}
+	sort.Strings(table.Partitions)
+	return c.save()
+}
+
+// save writes the catalog to a temporary file and renames it
+func (c *Catalog) save() error {
+	encoded, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, encoded, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// Export writes the hive DDL and the partitions of the tables, all tables without names
+func (c *Catalog) Export(w io.Writer, names ...string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(names) == 0 {
+		for name := range c.Tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		table, ok := c.Tables[name]
+		if !ok {
+			return fmt.Errorf("table %q: %v", name, ErrTableNotFound)
+		}
+		if _, err := io.WriteString(w, table.ddl(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ddl returns the create table and the add partition statements of the table
+func (t *Table) ddl(name string) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS `%s` (\n", name)
+
+	switch t.Format {
+	case TableJSON:
+		columns := make([]string, 0, len(t.Columns))
+		for column := range t.Columns {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for i, column := range columns {
+			fmt.Fprintf(&b, "  `%s` %s", column, t.Columns[column])
+			if i < len(columns)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(")\nPARTITIONED BY (`year` int, `month` int, `day` int)\n")
+		b.WriteString("ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'\n")
+	default:
+		b.WriteString("  `line` string\n)\nPARTITIONED BY (`year` int, `month` int, `day` int)\n")
+		b.WriteString("ROW FORMAT DELIMITED LINES TERMINATED BY '\\n'\n")
+	}
+	fmt.Fprintf(&b, "LOCATION '%s';\n", t.Location)
+
+	if len(t.Partitions) > 0 {
+		fmt.Fprintf(&b, "\nALTER TABLE `%s` ADD IF NOT EXISTS\n", name)
+		for _, p := range t.Partitions {
+			values := strings.Replace(p, "/", ", ", -1)
+			fmt.Fprintf(&b, "  PARTITION (%s) LOCATION '%s%s/'\n", values, t.Location, p)
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
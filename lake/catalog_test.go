@@ -0,0 +1,71 @@
+package lake
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := path.Join(dir, "s4.catalog")
+
+	c, err := OpenCatalog(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := OpenCatalog(name); shared != c {
+		t.Fatal("catalog of the same file is not shared")
+	}
+
+	location := "s3://test.quicket.s4/testresult/"
+	err = c.Add("testresult", location, []ManifestEntry{
+		{Key: "testresult/year=2017/month=8/day=4/host-0:1.txt.gz", Columns: map[string]string{"id": "bigint"}},
+		{Key: "testresult/year=2017/month=8/day=3/host-0:1.txt.gz", Columns: map[string]string{"id": "double", "name": "string"}},
+		{Key: "testresult/unknown/host-0:1.txt.gz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Add("testresult", location, []ManifestEntry{{Key: "testresult/year=2017/month=8/day=3/host-0:6.txt.gz"}})
+	_ = c.Add("lines", "s3://test.quicket.s4/lines/", []ManifestEntry{{Key: "lines/year=2017/month=8/day=3/host-0:6.txt.gz"}})
+
+	reopened := &Catalog{path: name}
+	raw, _ := ioutil.ReadFile(name)
+	if err := json.Unmarshal(raw, reopened); err != nil {
+		t.Fatal(err)
+	}
+	table := reopened.Tables["testresult"]
+	if table == nil || table.Format != TableJSON || len(table.Partitions) != 2 || table.Columns["id"] != "double" {
+		t.Fatalf("unexpected table: %+v", table)
+	}
+
+	var ddl bytes.Buffer
+	if err := c.Export(&ddl); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"CREATE EXTERNAL TABLE IF NOT EXISTS `lines` (\n  `line` string\n)",
+		"CREATE EXTERNAL TABLE IF NOT EXISTS `testresult` (\n  `id` double,\n  `name` string\n)",
+		"ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'\nLOCATION 's3://test.quicket.s4/testresult/';",
+		"  PARTITION (year=2017, month=8, day=3) LOCATION 's3://test.quicket.s4/testresult/year=2017/month=8/day=3/'\n",
+	} {
+		if !strings.Contains(ddl.String(), statement) {
+			t.Fatalf("%q is not exported:\n%s", statement, ddl.String())
+		}
+	}
+	if err := c.Export(&ddl, "missing"); err == nil {
+		t.Fatal("missing table is exported")
+	}
+	if TableName("logs/App-Events") != "app_events" {
+		t.Fatalf("unexpected table name: %s", TableName("logs/App-Events"))
+	}
+}
@@ -0,0 +1,401 @@
+package lake
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/findcoo/s4/logger"
+)
+
+const (
+	compactionDir = "_compactions"
+	compactedName = "compacted-"
+)
+
+var (
+	// ErrCompactVerify the merged object differs from the sources
+	ErrCompactVerify = errors.New("the merged object does not match the sources")
+)
+
+// Compactor merges the small objects of a partition into larger objects
+type Compactor struct {
+	// Reader lists and reads the sources, its keyring decrypts them
+	Reader *S3Reader
+	// Writer uploads the merged objects with its options
+	Writer *S3Supplyer
+	// TargetSize upper bound of the compressed size of the sources of a merged object
+	TargetSize int64
+	// manifests read by the run, they are read once and kept up to date by the merges
+	manifests map[string]*Manifest
+}
+
+// compaction a merge in progress, written before the merged object
+// so that an interrupted run deletes the remaining sources of a verified object
+type compaction struct {
+	Merged  string   `json:"merged"`
+	Sources []string `json:"sources"`
+	// ETags of the sources as they were read, a source overwritten since then is not deleted
+	ETags map[string]string `json:"etags,omitempty"`
+}
+
+// OpenCompactor returns a Compactor
+func OpenCompactor(region, bucket, key string, targetSize int64) (*Compactor, error) {
+	client, err := newS3Client(region)
+	if err != nil {
+		return nil, err
+	}
+	compactor := &Compactor{
+		Reader: &S3Reader{Bucket: bucket, Key: key, client: client},
+		Writer: &S3Supplyer{
+			Bucket: bucket,
+			Key:    key,
+			client: client,
+		},
+		TargetSize: targetSize,
+	}
+	compactor.Writer.Options.Idempotent = true
+	return compactor, nil
+}
+
+// planCompaction groups the objects of each partition in order up to the target size,
+// the objects already at the target size and the groups of a single object are left
+func planCompaction(objects []*Object, targetSize int64) [][]*Object {
+	var (
+		groups  [][]*Object
+		current []*Object
+		size    int64
+	)
+	byPrefix := make(map[string][]*Object)
+	var prefixes []string
+	for _, object := range objects {
+		prefix := path.Dir(object.Key)
+		if _, ok := byPrefix[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+		byPrefix[prefix] = append(byPrefix[prefix], object)
+	}
+
+	closeGroup := func() {
+		if len(current) > 1 {
+			groups = append(groups, current)
+		}
+		current, size = nil, 0
+	}
+	for _, prefix := range prefixes {
+		for _, object := range byPrefix[prefix] {
+			if object.Size >= targetSize {
+				closeGroup()
+				continue
+			}
+			if size+object.Size > targetSize {
+				closeGroup()
+			}
+			current = append(current, object)
+			size += object.Size
+		}
+		closeGroup()
+	}
+	return groups
+}
+
+// Plan returns the groups of the objects partitioned in [from, to) to merge,
+// the objects of the current and the previous minute may still be written by a flush and are left
+func (c *Compactor) Plan(from, to time.Time) ([][]*Object, error) {
+	objects, err := c.Reader.List(from, to)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-time.Minute).Truncate(time.Minute)
+	settled := objects[:0]
+	for _, object := range objects {
+		if object.Time.Before(cutoff) {
+			settled = append(settled, object)
+		}
+	}
+	return planCompaction(settled, c.TargetSize), nil
+}
+
+// Merge writes the records of the group as an object of the partition,
+// reads it back and deletes the sources, it returns the key of the merged object
+func (c *Compactor) Merge(group []*Object) (string, error) {
+	var data bytes.Buffer
+	etags := make(map[string]string)
+	for _, object := range group {
+		records, etag, err := c.Reader.read(object.Key)
+		if err != nil {
+			return "", err
+		}
+		etags[object.Key] = etag
+		data.Write(records)
+		if len(records) > 0 && records[len(records)-1] != '\n' {
+			data.WriteByte('\n')
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	p := &partition{
+		prefix: path.Dir(group[0].Key) + "/",
+		name:   compactedName + contentHash(data.Bytes()) + ".txt.gz",
+		data:   data.Bytes(),
+		first:  group[0].Time,
+		last:   group[len(group)-1].Time,
+	}
+	job := &compaction{Merged: p.prefix + p.name, ETags: etags}
+	for _, object := range group {
+		job.Sources = append(job.Sources, object.Key)
+	}
+	if err := c.putCompaction(job); err != nil {
+		return "", err
+	}
+
+	if _, _, err := c.Writer.put(context.Background(), p, hostname, time.Now()); err != nil {
+		return "", err
+	}
+	return job.Merged, c.finish(job)
+}
+
+// Resume finishes the merges interrupted by a previous run
+func (c *Compactor) Resume() error {
+	keys, err := c.list(fmt.Sprintf("%s/%s/", c.Reader.Key, compactionDir))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		job := &compaction{}
+		if err := c.getJSON(key, job); err != nil {
+			return err
+		}
+		exists, err := c.Writer.exists(context.Background(), job.Merged)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// the merged object was not written, the sources are intact
+			logger.With("object", job.Merged).Warnf("Discard the interrupted compaction")
+			err = c.delete(key)
+		} else {
+			logger.With("object", job.Merged).Infof("Resume the compaction")
+			err = c.finish(job)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list returns the keys under the prefix
+func (c *Compactor) list(prefix string) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.Reader.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := c.Reader.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, content := range page.Contents {
+			keys = append(keys, aws.StringValue(content.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// getJSON decodes the json object of the key into v
+func (c *Compactor) getJSON(key string, v interface{}) error {
+	output, err := c.Reader.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(c.Reader.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	encoded, err := ioutil.ReadAll(output.Body)
+	_ = output.Body.Close()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, v)
+}
+
+// head returns the head of the object, nil when it does not exist
+func (c *Compactor) head(key string) (*s3.HeadObjectOutput, error) {
+	output, err := c.Writer.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(c.Writer.Bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+		return nil, nil
+	}
+	return output, err
+}
+
+// verify reads the merged object back and checks the hash of its name, it returns the records
+func (c *Compactor) verify(key string) ([]byte, error) {
+	data, err := c.Reader.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(path.Base(key), ".txt.gz")
+	if name != compactedName+contentHash(data) {
+		return nil, ErrCompactVerify
+	}
+	return data, nil
+}
+
+// finish verifies the merged object, replaces the sources by it in the manifests and deletes them
+// with the compaction, a source overwritten since the merge read it is kept
+func (c *Compactor) finish(job *compaction) error {
+	data, err := c.verify(job.Merged)
+	if err != nil {
+		return err
+	}
+
+	var sources []string
+	for _, source := range job.Sources {
+		if source == job.Merged {
+			continue
+		}
+		head, err := c.head(source)
+		if err != nil {
+			return err
+		}
+		if head != nil && job.ETags[source] != "" && aws.StringValue(head.ETag) != job.ETags[source] {
+			logger.With("object", source).Warnf("Keep the source overwritten since the compaction read it")
+			continue
+		}
+		sources = append(sources, source)
+	}
+
+	if err := c.updateManifests(job.Merged, data, sources); err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if err := c.delete(source); err != nil {
+			return err
+		}
+	}
+	return c.delete(c.compactionKey(job.Merged))
+}
+
+// updateManifests removes the sources from the manifests of the flushes and lists the merged object
+// in a manifest of its own, nothing is written when the sources are not in any manifest
+func (c *Compactor) updateManifests(merged string, data []byte, sources []string) error {
+	keys, err := c.list(fmt.Sprintf("%s/%s/", c.Reader.Key, manifestDir))
+	if err != nil {
+		return err
+	}
+	if c.manifests == nil {
+		c.manifests = make(map[string]*Manifest)
+	}
+
+	removed := make(map[string]bool)
+	for _, source := range sources {
+		removed[source] = true
+	}
+	changed := make(map[string]*Manifest)
+	var entries []ManifestEntry
+	for _, key := range keys {
+		manifest, ok := c.manifests[key]
+		if !ok {
+			manifest = &Manifest{}
+			if err := c.getJSON(key, manifest); err != nil {
+				return err
+			}
+			c.manifests[key] = manifest
+		}
+		if found := manifest.removeEntries(removed); len(found) > 0 {
+			entries = append(entries, found...)
+			changed[key] = manifest
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	head, err := c.head(merged)
+	if err != nil {
+		return err
+	}
+	var size int
+	if head != nil {
+		size = int(aws.Int64Value(head.ContentLength))
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	p := &partition{data: data, first: entries[0].MinEventTime, last: entries[0].MaxEventTime}
+	for _, entry := range entries[1:] {
+		if entry.MinEventTime.Before(p.first) {
+			p.first = entry.MinEventTime
+		}
+		if entry.MaxEventTime.After(p.last) {
+			p.last = entry.MaxEventTime
+		}
+	}
+	// the merged manifest is written first, an interrupted update leaves the sources in the others
+	manifest := &Manifest{Hostname: hostname, FlushedAt: time.Now(), Objects: []ManifestEntry{p.entry(merged, size)}}
+	name := strings.TrimSuffix(path.Base(merged), ".txt.gz")
+	if err := c.Writer.writeManifest(fmt.Sprintf("%s/%s/%s.json", c.Reader.Key, manifestDir, name), manifest); err != nil {
+		return err
+	}
+	for key, manifest := range changed {
+		if len(manifest.Objects) > 0 {
+			err = c.Writer.writeManifest(key, manifest)
+		} else {
+			err = c.delete(key)
+			delete(c.manifests, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactionKey returns the key of the compaction of the merged object
+func (c *Compactor) compactionKey(merged string) string {
+	name := strings.TrimSuffix(path.Base(merged), ".txt.gz")
+	return fmt.Sprintf("%s/%s/%s.json", c.Reader.Key, compactionDir, name)
+}
+
+// putCompaction uploads the compaction before the merged object
+func (c *Compactor) putCompaction(job *compaction) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	obj := &s3.PutObjectInput{
+		Body:        aws.ReadSeekCloser(bytes.NewReader(encoded)),
+		Bucket:      aws.String(c.Writer.Bucket),
+		Key:         aws.String(c.compactionKey(job.Merged)),
+		ContentType: aws.String("application/json"),
+	}
+	obj.ServerSideEncryption, obj.SSEKMSKeyId = c.Writer.Options.serverSideEncryption()
+	_, err = c.Writer.client.PutObject(obj)
+	return err
+}
+
+func (c *Compactor) delete(key string) error {
+	_, err := c.Writer.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(c.Writer.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
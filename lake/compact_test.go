@@ -0,0 +1,46 @@
+package lake
+
+import (
+	"testing"
+)
+
+func TestPlanCompaction(t *testing.T) {
+	day := "testresult/year=2017/month=8/day=3/"
+	next := "testresult/year=2017/month=8/day=4/"
+	objects := []*Object{
+		{Key: day + "host-14:1.txt.gz", Size: 40},
+		{Key: day + "host-14:2.txt.gz", Size: 40},
+		{Key: day + "host-14:3.txt.gz", Size: 40},
+		{Key: day + "host-14:4.txt.gz", Size: 100},
+		{Key: day + "host-14:5.txt.gz", Size: 10},
+		{Key: next + "host-0:1.txt.gz", Size: 10},
+		{Key: next + "host-0:2.txt.gz", Size: 10},
+	}
+
+	groups := planCompaction(objects, 100)
+	if len(groups) != 2 {
+		t.Fatalf("unexpected groups: %d", len(groups))
+	}
+	if len(groups[0]) != 2 || groups[0][0] != objects[0] || groups[0][1] != objects[1] {
+		t.Fatalf("unexpected first group: %v", groups[0])
+	}
+	// the objects of the other partition are not merged with the single object of the day
+	if len(groups[1]) != 2 || groups[1][0] != objects[5] || groups[1][1] != objects[6] {
+		t.Fatalf("unexpected second group: %v", groups[1])
+	}
+}
+
+func TestCompactionKey(t *testing.T) {
+	compactor := &Compactor{Reader: &S3Reader{Key: "testresult"}}
+	merged := "testresult/year=2017/month=8/day=3/" + compactedName + contentHash([]byte("a\n")) + ".txt.gz"
+	key := compactor.compactionKey(merged)
+	if key != "testresult/_compactions/"+compactedName+contentHash([]byte("a\n"))+".json" {
+		t.Fatalf("unexpected key: %s", key)
+	}
+	if _, ok := PartitionTime(key); ok {
+		t.Fatal("compaction must not be listed as an object")
+	}
+	if _, ok := PartitionTime(merged); !ok {
+		t.Fatal("merged object must be listed")
+	}
+}
@@ -3,14 +3,42 @@ package lake
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/logger"
+)
+
+// server-side encryptions of the S3Supplyer
+const (
+	EncryptionS3  = "sse-s3"
+	EncryptionKMS = "sse-kms"
+)
+
+// metadata of the objects written by the S3Supplyer
+const (
+	MetaHostname       = "s4-hostname"
+	MetaRecords        = "s4-records"
+	MetaFirstTimestamp = "s4-first-timestamp"
+	MetaLastTimestamp  = "s4-last-timestamp"
+	// MetaFirstOffset and MetaLastOffset offsets of the batch in the buffer of the river
+	MetaFirstOffset = "s4-first-offset"
+	MetaLastOffset  = "s4-last-offset"
+	// MetaDataKey base64 of the wrapped data key of the client-side encryption
+	MetaDataKey = "s4-data-key"
 )
 
 // Supplyer data-lake interface
@@ -18,11 +46,62 @@ type Supplyer interface {
 	Push(data []byte) error
 }
 
+// S3Options options of the objects written by the S3Supplyer
+type S3Options struct {
+	// Encryption sse-s3 or sse-kms, empty follows the default of the bucket
+	Encryption string
+	// KMSKeyID key of the sse-kms, empty uses the aws managed key
+	KMSKeyID     string
+	StorageClass string
+	// ACL canned ACL of the objects
+	ACL      string
+	Tags     map[string]string
+	Metadata map[string]string
+	// CompressionLevel gzip level of the objects from 1 to 9, zero is the default level
+	CompressionLevel int
+	// Timeout cancels the upload of an object, zero waits
+	Timeout time.Duration
+	// Envelope encrypts the compressed objects on the client side with the master keys
+	Envelope *crypt.Keyring
+	// EventTime partitions the json records by their timestamps
+	EventTime *EventTime
+	// Idempotent names the objects by the hash of the source, the offsets and the records and skips the uploaded ones,
+	// a batch pushed again after a failure or a restart does not duplicate the data
+	Idempotent bool
+	// Manifest writes a manifest of the objects of each flush
+	Manifest bool
+	// Catalog records the partitions and the columns of the Table
+	Catalog *Catalog
+	Table   string
+}
+
+// serverSideEncryption returns the encryption and the kms key of the PutObjectInput
+func (o S3Options) serverSideEncryption() (*string, *string) {
+	switch o.Encryption {
+	case EncryptionS3:
+		return aws.String(s3.ServerSideEncryptionAes256), nil
+	case EncryptionKMS:
+		if o.KMSKeyID != "" {
+			return aws.String(s3.ServerSideEncryptionAwsKms), aws.String(o.KMSKeyID)
+		}
+		return aws.String(s3.ServerSideEncryptionAwsKms), nil
+	}
+	return nil, nil
+}
+
 // S3Supplyer AWS S3 data-lake
 type S3Supplyer struct {
-	Bucket string
-	Key    string
+	Bucket  string
+	Key     string
+	Options S3Options
+	// Logger logs with the fields of the pipeline, nil logs without fields
+	Logger *logger.Entry
 	client *s3.S3
+	mutex  *sync.Mutex
+	// since the last push, the first timestamp of the next object
+	since time.Time
+	// seq numbers the pushes of the batches without offsets
+	seq uint64
 }
 
 // ConsoleSupplyer commonly use for debugging
@@ -44,47 +123,305 @@ func (cs *ConsoleSupplyer) Push(data []byte) error {
 	return err
 }
 
-// NewS3Supplyer create s3 client
-func NewS3Supplyer(region, bucket, key string) *S3Supplyer {
+// Write prints the data of the batch
+func (cs *ConsoleSupplyer) Write(ctx context.Context, batch *Batch) error {
+	return cs.Push(batch.Data)
+}
+
+// Flush nothing is held by the console
+func (cs *ConsoleSupplyer) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close the stdout is left open
+func (cs *ConsoleSupplyer) Close() error {
+	return nil
+}
+
+// EnvelopeSupplyer encrypts the data with the master keys before the Supplyer,
+// the wrapped data key is framed with the data for the supplyers without metadata
+type EnvelopeSupplyer struct {
+	Keyring *crypt.Keyring
+	Supplyer
+}
+
+// Push encrypts the data with a new data key and pushes the marshaled envelope
+func (es *EnvelopeSupplyer) Push(data []byte) error {
+	envelope, err := es.Keyring.SealEnvelope(data)
+	if err != nil {
+		return err
+	}
+	return es.Supplyer.Push(envelope.Marshal())
+}
+
+// MultiSupplyer pushes to every supplyer
+type MultiSupplyer []Supplyer
+
+// Push push data to every supplyer, returns the first error
+func (ms MultiSupplyer) Push(data []byte) error {
+	var first error
+	for _, s := range ms {
+		if err := s.Push(data); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func newS3Client(region string) (*s3.S3, error) {
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(region),
 	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+// dayPartition returns the day partition of the time
+func dayPartition(t time.Time) string {
+	return fmt.Sprintf("year=%d/month=%d/day=%d", t.Year(), int(t.Month()), t.Day())
+}
+
+// partitionPrefix returns the prefix of the day partition
+func partitionPrefix(key string, t time.Time) string {
+	return fmt.Sprintf("%s/%s/", key, dayPartition(t))
+}
+
+// NewS3Supplyer create s3 client, it exits the process on a failure
+func NewS3Supplyer(region, bucket, key string) *S3Supplyer {
+	s3supplyer, err := OpenS3Supplyer(region, bucket, key)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return s3supplyer
+}
 
+// OpenS3Supplyer returns a S3Supplyer
+func OpenS3Supplyer(region, bucket, key string) (*S3Supplyer, error) {
+	client, err := newS3Client(region)
+	if err != nil {
+		return nil, err
+	}
 	s3supplyer := &S3Supplyer{
 		Bucket: bucket,
 		Key:    key,
-		client: s3.New(sess),
+		client: client,
+		mutex:  &sync.Mutex{},
+		since:  time.Now(),
 	}
-	return s3supplyer
+	return s3supplyer, nil
 }
 
-// Push push data to s3 bucket
+// Push push data to s3 bucket, an object for each partition
 func (sl *S3Supplyer) Push(data []byte) error {
+	return sl.Write(context.Background(), NewBatch(data))
+}
+
+// Write uploads an object for each partition of the batch, the context cancels the uploads
+func (sl *S3Supplyer) Write(ctx context.Context, batch *Batch) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	now := time.Now()
+	manifest := &Manifest{Hostname: hostname, FlushedAt: now}
+	sl.seq++
+	for _, p := range sl.partitions(batch.Data, now) {
+		if batch.Partition != "" && sl.Options.EventTime == nil {
+			p.prefix = fmt.Sprintf("%s/%s/", sl.Key, batch.PartitionAt(now))
+		}
+		if batch.Name != "" {
+			p.name = batch.Name
+		}
+		p.source, p.firstOffset, p.lastOffset, p.seq = batch.Source, batch.FirstOffset, batch.LastOffset, sl.seq
+		// a partition put before a failure of the batch is not uploaded again under another key
+		partition := sl.Bucket + "/" + p.prefix
+		object, ok := batch.uploaded[partition]
+		if ok {
+			sl.Logger.With("object", object.key).Infof("Skip the object uploaded by the failed push of the batch")
+		} else {
+			key, size, err := sl.put(ctx, p, hostname, now)
+			if err != nil {
+				return err
+			}
+			object = uploadedObject{key: key, size: size}
+			if batch.uploaded == nil {
+				batch.uploaded = make(map[string]uploadedObject)
+			}
+			batch.uploaded[partition] = object
+		}
+		if sl.Options.Manifest || sl.Options.Catalog != nil {
+			manifest.Objects = append(manifest.Objects, p.entry(object.key, object.size))
+		}
+	}
+	sl.since = now
+
+	// the objects are uploaded, the failures of the manifest and the catalog do not fail the push
+	if sl.Options.Manifest {
+		if err := sl.putManifest(manifest); err != nil {
+			sl.Logger.Warnf("Put the manifest: %v", err)
+		}
+	}
+	if sl.Options.Catalog != nil {
+		location := fmt.Sprintf("s3://%s/%s/", sl.Bucket, sl.Key)
+		if err := sl.Options.Catalog.Add(sl.Options.Table, location, manifest.Objects); err != nil {
+			sl.Logger.Warnf("Update the catalog: %v", err)
+		}
+	}
+	return nil
+}
+
+// Flush every batch is uploaded by the Write
+func (sl *S3Supplyer) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close the client has nothing to release
+func (sl *S3Supplyer) Close() error {
+	return nil
+}
+
+// put compresses, encrypts and uploads the partition, it returns the key and the size of the object
+func (sl *S3Supplyer) put(ctx context.Context, p *partition, hostname string, now time.Time) (string, int, error) {
+	level := sl.Options.CompressionLevel
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
 	var compressed bytes.Buffer
-	gzw := gzip.NewWriter(&compressed)
-	_, err := gzw.Write(data)
+	gzw, err := gzip.NewWriterLevel(&compressed, level)
+	if err != nil {
+		return "", 0, err
+	}
+	_, err = gzw.Write(p.data)
 	if err != nil {
 		_ = gzw.Close()
-		return err
+		return "", 0, err
 	}
 	_ = gzw.Close()
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		return err
+	obj := sl.putInput(p, hostname, now)
+	log := sl.Logger.With("object", aws.StringValue(obj.Key), "first_offset", p.firstOffset, "last_offset", p.lastOffset)
+	if sl.Options.Idempotent {
+		exists, err := sl.exists(ctx, aws.StringValue(obj.Key))
+		if err != nil {
+			return "", 0, err
+		}
+		if exists {
+			log.Infof("Skip the uploaded object")
+			return aws.StringValue(obj.Key), compressed.Len(), nil
+		}
+	}
+	body := compressed.Bytes()
+	if sl.Options.Envelope != nil {
+		envelope, err := sl.Options.Envelope.SealEnvelope(body)
+		if err != nil {
+			return "", 0, err
+		}
+		body = envelope.Ciphertext
+		obj.Metadata[MetaDataKey] = aws.String(base64.StdEncoding.EncodeToString(envelope.WrappedKey))
+	}
+	obj.Body = aws.ReadSeekCloser(bytes.NewReader(body))
+
+	if sl.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sl.Options.Timeout)
+		defer cancel()
+	}
+	if _, err = sl.client.PutObjectWithContext(ctx, obj); err != nil {
+		log.Errorf("Put the object: %v", err)
+	} else {
+		log.Debugf("Put the object of %d bytes", len(body))
+	}
+	return aws.StringValue(obj.Key), len(body), err
+}
+
+// contentHash names the object by the records so that a replayed batch has the same key
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// batchHash names the records by the source and the offsets of their batch,
+// a batch pushed again has the same key and the same records of other offsets do not collide
+func batchHash(source string, firstOffset, lastOffset uint64, data []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00", source, firstOffset, lastOffset)
+	_, _ = h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// exists reports whether the object is uploaded, a missing object is forbidden
+// instead of not found without the s3:ListBucket permission and it is uploaded again
+func (sl *S3Supplyer) exists(ctx context.Context, key string) (bool, error) {
+	_, err := sl.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(sl.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NotFound", s3.ErrCodeNoSuchKey, "Forbidden":
+			return false, nil
+		}
+	}
+	return false, err
+}
+
+// putInput returns the PutObjectInput of the partition without the body,
+// the first and the last timestamps of the metadata are the event times of the partition
+// or the window of the buffer since the last push. The objects of a minute are suffixed
+// by the first offset of their batch or by the sequence of the push when it has no offsets
+func (sl *S3Supplyer) putInput(p *partition, hostname string, now time.Time) *s3.PutObjectInput {
+	unique := p.seq
+	if p.lastOffset > 0 {
+		unique = p.firstOffset
+	}
+	timePartition := fmt.Sprintf("%s%s-%d:%d-%d.txt.gz", p.prefix, hostname, now.Hour(), now.Minute(), unique)
+	if sl.Options.Idempotent {
+		timePartition = fmt.Sprintf("%s%s-%s.txt.gz", p.prefix, hostname, batchHash(p.source, p.firstOffset, p.lastOffset, p.data))
+	}
+	if p.name != "" {
+		timePartition = p.prefix + p.name
 	}
-	now := time.Now()
-	key := sl.Key
-	timePartition := fmt.Sprintf("%s/year=%d/month=%d/day=%d/%s-%d:%d.txt.gz", key, now.Year(), int(now.Month()), now.Day(), hostname, now.Hour(), now.Minute())
 	obj := &s3.PutObjectInput{
-		Body:   aws.ReadSeekCloser(bytes.NewReader(compressed.Bytes())),
 		Bucket: aws.String(sl.Bucket),
 		Key:    aws.String(timePartition),
 	}
 
-	_, err = sl.client.PutObject(obj)
-	return err
+	metadata := map[string]string{
+		MetaHostname:       hostname,
+		MetaRecords:        fmt.Sprint(bytes.Count(p.data, []byte("\n"))),
+		MetaFirstTimestamp: p.first.Format(time.RFC3339),
+		MetaLastTimestamp:  p.last.Format(time.RFC3339),
+	}
+	if p.lastOffset > 0 {
+		metadata[MetaFirstOffset] = fmt.Sprint(p.firstOffset)
+		metadata[MetaLastOffset] = fmt.Sprint(p.lastOffset)
+	}
+	for k, v := range sl.Options.Metadata {
+		metadata[k] = v
+	}
+	obj.Metadata = aws.StringMap(metadata)
+
+	obj.ServerSideEncryption, obj.SSEKMSKeyId = sl.Options.serverSideEncryption()
+	if sl.Options.StorageClass != "" {
+		obj.StorageClass = aws.String(sl.Options.StorageClass)
+	}
+	if sl.Options.ACL != "" {
+		obj.ACL = aws.String(sl.Options.ACL)
+	}
+	if len(sl.Options.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range sl.Options.Tags {
+			tags.Set(k, v)
+		}
+		obj.Tagging = aws.String(tags.Encode())
+	}
+	return obj
 }
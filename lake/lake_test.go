@@ -1,10 +1,135 @@
 package lake
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/findcoo/s4/crypt"
 )
 
 func TestS3Supplyer(t *testing.T) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" && os.Getenv("AWS_PROFILE") == "" {
+		t.Skip("no aws credentials")
+	}
 	s3 := NewS3Supplyer("ap-northeast-2", "test.quicket.s4", "testresult")
 	s3.Push([]byte("hello world, this is s3 supplyer test"))
 }
+
+func TestPutInput(t *testing.T) {
+	since := time.Date(2017, 8, 3, 14, 0, 0, 0, time.UTC)
+	sl := &S3Supplyer{
+		Bucket: "test.quicket.s4",
+		Key:    "testresult",
+		Options: S3Options{
+			Encryption:   EncryptionKMS,
+			KMSKeyID:     "alias/s4",
+			StorageClass: "STANDARD_IA",
+			ACL:          "bucket-owner-full-control",
+			Tags:         map[string]string{"team": "data", "env": "prod"},
+			Metadata:     map[string]string{"source": "app"},
+		},
+		since: since,
+	}
+
+	obj := sl.putInput(sl.partitions([]byte("a\nb\nc\n"), since.Add(time.Minute*5))[0], "host", since.Add(time.Minute*5))
+	if aws.StringValue(obj.Key) != "testresult/year=2017/month=8/day=3/host-14:5-0.txt.gz" {
+		t.Fatalf("unexpected key: %s", aws.StringValue(obj.Key))
+	}
+	minute := &partition{prefix: "testresult/year=2017/month=8/day=3/", data: []byte("a\n"), firstOffset: 1, lastOffset: 1}
+	concurrent := &partition{prefix: minute.prefix, data: minute.data, firstOffset: 2, lastOffset: 2}
+	if key := aws.StringValue(sl.putInput(minute, "host", since).Key); key != minute.prefix+"host-14:0-1.txt.gz" ||
+		key == aws.StringValue(sl.putInput(concurrent, "host", since).Key) {
+		t.Fatalf("objects of the same minute have the same key: %s", key)
+	}
+	if aws.StringValue(obj.ServerSideEncryption) != "aws:kms" || aws.StringValue(obj.SSEKMSKeyId) != "alias/s4" {
+		t.Fatalf("unexpected encryption: %v %v", obj.ServerSideEncryption, obj.SSEKMSKeyId)
+	}
+	if aws.StringValue(obj.StorageClass) != "STANDARD_IA" || aws.StringValue(obj.ACL) != "bucket-owner-full-control" {
+		t.Fatalf("unexpected storage class or acl: %v %v", obj.StorageClass, obj.ACL)
+	}
+	if aws.StringValue(obj.Tagging) != "env=prod&team=data" {
+		t.Fatalf("unexpected tagging: %s", aws.StringValue(obj.Tagging))
+	}
+
+	metadata := aws.StringValueMap(obj.Metadata)
+	if metadata[MetaRecords] != "3" || metadata[MetaHostname] != "host" || metadata["source"] != "app" ||
+		metadata[MetaFirstTimestamp] != "2017-08-03T14:00:00Z" || metadata[MetaLastTimestamp] != "2017-08-03T14:05:00Z" {
+		t.Fatalf("unexpected metadata: %v", metadata)
+	}
+
+	sl.Options.Idempotent = true
+	p := &partition{prefix: "testresult/year=2017/month=8/day=3/", data: []byte("a\n")}
+	first, second := sl.putInput(p, "host", since), sl.putInput(p, "host", since.Add(time.Hour))
+	if aws.StringValue(first.Key) != aws.StringValue(second.Key) ||
+		aws.StringValue(first.Key) != "testresult/year=2017/month=8/day=3/host-"+batchHash("", 0, 0, p.data)+".txt.gz" {
+		t.Fatalf("key of the same records is changed: %s %s", aws.StringValue(first.Key), aws.StringValue(second.Key))
+	}
+	if other := sl.putInput(&partition{prefix: p.prefix, data: []byte("b\n")}, "host", since); aws.StringValue(other.Key) == aws.StringValue(first.Key) {
+		t.Fatal("key of the other records is not changed")
+	}
+	batched := &partition{prefix: p.prefix, data: p.data, source: "./tmp", firstOffset: 1, lastOffset: 1}
+	next := &partition{prefix: p.prefix, data: p.data, source: "./tmp", firstOffset: 2, lastOffset: 2}
+	if aws.StringValue(sl.putInput(batched, "host", since).Key) == aws.StringValue(sl.putInput(next, "host", since).Key) {
+		t.Fatal("same records of other offsets have the same key")
+	}
+
+	plain := (&S3Supplyer{Key: "testresult"}).putInput(&partition{}, "host", since)
+	if plain.ServerSideEncryption != nil || plain.Tagging != nil || plain.ACL != nil {
+		t.Fatalf("options are set without the configuration: %+v", plain)
+	}
+}
+
+type captureSupplyer struct {
+	data []byte
+}
+
+func (cs *captureSupplyer) Push(data []byte) error {
+	cs.data = append(cs.data, data...)
+	return nil
+}
+
+func TestEnvelope(t *testing.T) {
+	os.Setenv("S4_TEST_MASTER_KEY", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("S4_TEST_MASTER_KEY")
+	master, err := crypt.ParseKeyring([]string{"master=env:S4_TEST_MASTER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := &captureSupplyer{}
+	es := &EnvelopeSupplyer{Keyring: master, Supplyer: capture}
+	if err := es.Push([]byte("secret\n")); err != nil || bytes.Contains(capture.data, []byte("secret")) {
+		t.Fatalf("data is not encrypted: %q %v", capture.data, err)
+	}
+	envelope, err := crypt.ReadEnvelope(bytes.NewReader(capture.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]*string{"S4-Data-Key": aws.String(base64.StdEncoding.EncodeToString(envelope.WrappedKey))}
+	if data, err := Decrypt(master, envelope.Ciphertext, metadata); err != nil || string(data) != "secret\n" {
+		t.Fatalf("unexpected decryption %q: %v", data, err)
+	}
+	if data, err := Decrypt(master, []byte("plain"), nil); err != nil || string(data) != "plain" {
+		t.Fatalf("plain object is changed %q: %v", data, err)
+	}
+}
+
+func TestWriteUploadedPartition(t *testing.T) {
+	sl := &S3Supplyer{Bucket: "test.s4", Key: "testresult", mutex: &sync.Mutex{}}
+	batch := NewBatch([]byte("a\n"))
+	batch.Partition = "year=2017/month=8/day=3"
+	batch.uploaded = map[string]uploadedObject{
+		"test.s4/testresult/year=2017/month=8/day=3/": {key: "testresult/year=2017/month=8/day=3/host-14:5.txt.gz", size: 22},
+	}
+	// the client is not set, the write panics if the partition is put again
+	if err := sl.Write(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,167 @@
+package lake
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// manifestDir hides the manifests from the tables, hive skips the names starting with an underscore
+const manifestDir = "_manifests"
+
+// Manifest objects written by a flush
+type Manifest struct {
+	Hostname  string          `json:"hostname"`
+	FlushedAt time.Time       `json:"flushed_at"`
+	Objects   []ManifestEntry `json:"objects"`
+}
+
+// ManifestEntry an object of the Manifest
+type ManifestEntry struct {
+	Key     string `json:"key"`
+	Records int    `json:"records"`
+	// Bytes size of the object, RawBytes size of the records before the compression
+	Bytes        int       `json:"bytes"`
+	RawBytes     int       `json:"raw_bytes"`
+	MinEventTime time.Time `json:"min_event_time"`
+	MaxEventTime time.Time `json:"max_event_time"`
+	Schema       string    `json:"schema"`
+	// Columns hive types of the json fields, empty for the lines
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// hiveType returns the hive type of a json value, nil has no type
+func hiveType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "double"
+		}
+		return "bigint"
+	case []interface{}:
+		return "array<string>"
+	case map[string]interface{}:
+		return "string"
+	}
+	return ""
+}
+
+// widen returns the type holding both types
+func widen(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case (a == "bigint" && b == "double") || (a == "double" && b == "bigint"):
+		return "double"
+	}
+	return "string"
+}
+
+// schemaOf returns the columns of the json records and the fingerprint of them,
+// the records that are not json objects are lines without columns
+func schemaOf(data []byte) (map[string]string, string) {
+	columns := make(map[string]string)
+	for len(data) > 0 {
+		var record []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			record, data = data[:i+1], data[i+1:]
+		} else {
+			record, data = data, nil
+		}
+
+		var object map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(record))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			continue
+		}
+		for name, value := range object {
+			if t := hiveType(value); t != "" {
+				columns[name] = widen(columns[name], t)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return nil, "line"
+	}
+
+	names := make([]string, 0, len(columns))
+	for name, t := range columns {
+		names = append(names, name+":"+t)
+	}
+	sort.Strings(names)
+	sum := sha256.Sum256([]byte(strings.Join(names, ",")))
+	return columns, hex.EncodeToString(sum[:8])
+}
+
+// entry returns the ManifestEntry of the partition uploaded to the key
+func (p *partition) entry(key string, size int) ManifestEntry {
+	columns, fingerprint := schemaOf(p.data)
+	return ManifestEntry{
+		Key:          key,
+		Records:      bytes.Count(p.data, []byte("\n")),
+		Bytes:        size,
+		RawBytes:     len(p.data),
+		MinEventTime: p.first,
+		MaxEventTime: p.last,
+		Schema:       fingerprint,
+		Columns:      columns,
+	}
+}
+
+// removeEntries removes the entries of the keys from the manifest and returns them
+func (m *Manifest) removeEntries(keys map[string]bool) []ManifestEntry {
+	var removed []ManifestEntry
+	kept := m.Objects[:0]
+	for _, entry := range m.Objects {
+		if keys[entry.Key] {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	m.Objects = kept
+	return removed
+}
+
+// manifestKey returns the key of the manifest of a flush
+func (sl *S3Supplyer) manifestKey(hostname string, now time.Time) string {
+	return fmt.Sprintf("%s/%s/%s-%d.json", sl.Key, manifestDir, hostname, now.UnixNano())
+}
+
+// putManifest uploads the manifest of the flush
+func (sl *S3Supplyer) putManifest(manifest *Manifest) error {
+	return sl.writeManifest(sl.manifestKey(manifest.Hostname, manifest.FlushedAt), manifest)
+}
+
+// writeManifest uploads the manifest to the key
+func (sl *S3Supplyer) writeManifest(key string, manifest *Manifest) error {
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	obj := &s3.PutObjectInput{
+		Body:        aws.ReadSeekCloser(bytes.NewReader(encoded)),
+		Bucket:      aws.String(sl.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+	}
+	obj.ServerSideEncryption, obj.SSEKMSKeyId = sl.Options.serverSideEncryption()
+	_, err = sl.client.PutObject(obj)
+	return err
+}
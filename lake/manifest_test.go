@@ -0,0 +1,63 @@
+package lake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchemaOf(t *testing.T) {
+	columns, fingerprint := schemaOf([]byte(`{"id": 1, "score": 1, "name": "a", "tags": ["x"]}` + "\n" +
+		`{"id": 2, "score": 1.5, "name": "b", "ok": true, "extra": null}` + "\n"))
+	expected := map[string]string{"id": "bigint", "score": "double", "name": "string", "tags": "array<string>", "ok": "boolean"}
+	if len(columns) != len(expected) {
+		t.Fatalf("unexpected columns: %v", columns)
+	}
+	for column, hive := range expected {
+		if columns[column] != hive {
+			t.Fatalf("unexpected type of %s: %s", column, columns[column])
+		}
+	}
+
+	_, reordered := schemaOf([]byte(`{"ok": false, "tags": [], "name": "c", "score": 2.5, "id": 3}`))
+	if reordered != fingerprint || len(fingerprint) != 16 {
+		t.Fatalf("fingerprint depends on the order: %s %s", fingerprint, reordered)
+	}
+	if columns, fingerprint := schemaOf([]byte("plain line\n")); columns != nil || fingerprint != "line" {
+		t.Fatalf("unexpected schema of the lines: %v %s", columns, fingerprint)
+	}
+}
+
+func TestManifestEntry(t *testing.T) {
+	first := time.Date(2017, 8, 3, 14, 0, 0, 0, time.UTC)
+	p := &partition{
+		prefix: "testresult/year=2017/month=8/day=3/",
+		data:   []byte(`{"id": 1}` + "\n" + `{"id": 2}` + "\n"),
+		first:  first,
+		last:   first.Add(time.Minute),
+	}
+
+	entry := p.entry("testresult/year=2017/month=8/day=3/host-14:1.txt.gz", 42)
+	if entry.Records != 2 || entry.Bytes != 42 || entry.RawBytes != 20 || entry.Columns["id"] != "bigint" ||
+		!entry.MinEventTime.Equal(first) || !entry.MaxEventTime.Equal(first.Add(time.Minute)) {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+
+	sl := &S3Supplyer{Key: "testresult"}
+	if key := sl.manifestKey("host", time.Unix(0, 7)); key != "testresult/_manifests/host-7.json" {
+		t.Fatalf("unexpected manifest key: %s", key)
+	}
+}
+
+func TestRemoveEntries(t *testing.T) {
+	manifest := &Manifest{Objects: []ManifestEntry{{Key: "a"}, {Key: "b"}, {Key: "c"}}}
+	removed := manifest.removeEntries(map[string]bool{"a": true, "c": true, "d": true})
+	if len(removed) != 2 || removed[0].Key != "a" || removed[1].Key != "c" {
+		t.Fatalf("unexpected removed entries: %+v", removed)
+	}
+	if len(manifest.Objects) != 1 || manifest.Objects[0].Key != "b" {
+		t.Fatalf("unexpected kept entries: %+v", manifest.Objects)
+	}
+	if removed := manifest.removeEntries(map[string]bool{"a": true}); removed != nil {
+		t.Fatalf("removed entries are found again: %+v", removed)
+	}
+}
@@ -0,0 +1,147 @@
+package lake
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats of the EventTime
+const (
+	FormatRFC3339 = "rfc3339"
+	FormatUnix    = "unix"
+	FormatUnixMs  = "unix_ms"
+)
+
+// EventTime partitions the json records by the time of a field instead of the upload time
+type EventTime struct {
+	// Field dotted path of the timestamp field
+	Field string
+	// Format rfc3339, unix, unix_ms or a layout of the time package, rfc3339 by default
+	Format string
+	// Fallback prefix under the key of the records without a valid timestamp,
+	// empty falls back to the partition of the upload time
+	Fallback string
+}
+
+// partition records of an object
+type partition struct {
+	prefix string
+	// name of the object under the prefix, empty is named by the hostname
+	name  string
+	data  []byte
+	first time.Time
+	last  time.Time
+	// source and offsets of the batch of the partition
+	source      string
+	firstOffset uint64
+	lastOffset  uint64
+	// seq of the push, it names the partition of a batch without offsets
+	seq uint64
+}
+
+// Parse returns the event time of the json record
+func (et *EventTime) Parse(record []byte) (time.Time, bool) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return time.Time{}, false
+	}
+
+	path := strings.Split(et.Field, ".")
+	for _, name := range path[:len(path)-1] {
+		child, ok := object[name].(map[string]interface{})
+		if !ok {
+			return time.Time{}, false
+		}
+		object = child
+	}
+
+	var value string
+	switch v := object[path[len(path)-1]].(type) {
+	case string:
+		value = v
+	case json.Number:
+		value = v.String()
+	default:
+		return time.Time{}, false
+	}
+
+	switch et.Format {
+	case "", FormatRFC3339:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	case FormatUnix, FormatUnixMs:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if et.Format == FormatUnixMs {
+			n /= 1000
+		}
+		return time.Unix(0, int64(n*float64(time.Second))), true
+	}
+	t, err := time.Parse(et.Format, value)
+	return t, err == nil
+}
+
+// partitions splits the data into the partitions of the event time,
+// the data is a single partition of the upload time without the EventTime
+func (sl *S3Supplyer) partitions(data []byte, now time.Time) []*partition {
+	et := sl.Options.EventTime
+	if et == nil || et.Field == "" {
+		return []*partition{{prefix: partitionPrefix(sl.Key, now), data: data, first: sl.since, last: now}}
+	}
+
+	partitions := make(map[string]*partition)
+	// timed the partitions whose first and last are event times instead of the upload window
+	timed := make(map[string]bool)
+	for len(data) > 0 {
+		var record []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			record, data = data[:i+1], data[i+1:]
+		} else {
+			record, data = data, nil
+		}
+
+		t, ok := et.Parse(record)
+		var prefix string
+		switch {
+		case ok:
+			t = t.In(now.Location())
+			prefix = partitionPrefix(sl.Key, t)
+		case et.Fallback != "":
+			prefix = sl.Key + "/" + et.Fallback + "/"
+		default:
+			prefix = partitionPrefix(sl.Key, now)
+		}
+
+		p, exists := partitions[prefix]
+		if !exists {
+			p = &partition{prefix: prefix, first: sl.since, last: now}
+			partitions[prefix] = p
+		}
+		switch {
+		case ok && !timed[prefix]:
+			p.first, p.last, timed[prefix] = t, t, true
+		case ok && t.Before(p.first):
+			p.first = t
+		case ok && t.After(p.last):
+			p.last = t
+		}
+		p.data = append(p.data, record...)
+	}
+
+	sorted := make([]*partition, 0, len(partitions))
+	for _, p := range partitions {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].prefix < sorted[j].prefix
+	})
+	return sorted
+}
@@ -0,0 +1,75 @@
+package lake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventTimeParse(t *testing.T) {
+	expected := time.Date(2017, 8, 3, 23, 59, 30, 0, time.UTC)
+	for _, c := range []struct {
+		et     EventTime
+		record string
+	}{
+		{EventTime{Field: "ts"}, `{"ts": "2017-08-03T23:59:30Z"}`},
+		{EventTime{Field: "meta.ts", Format: FormatUnix}, `{"meta": {"ts": 1501804770}}`},
+		{EventTime{Field: "ts", Format: FormatUnixMs}, `{"ts": "1501804770000"}`},
+		{EventTime{Field: "ts", Format: "2006-01-02 15:04:05"}, `{"ts": "2017-08-03 23:59:30"}`},
+	} {
+		parsed, ok := c.et.Parse([]byte(c.record))
+		if !ok || !parsed.Equal(expected) {
+			t.Fatalf("unexpected time of %s: %s %v", c.record, parsed, ok)
+		}
+	}
+
+	et := EventTime{Field: "ts"}
+	for _, record := range []string{`{"message": "no ts"}`, `{"ts": "yesterday"}`, `plain`} {
+		if _, ok := et.Parse([]byte(record)); ok {
+			t.Fatalf("invalid timestamp is parsed: %s", record)
+		}
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	now := time.Date(2017, 8, 4, 0, 0, 10, 0, time.UTC)
+	sl := &S3Supplyer{
+		Key:     "testresult",
+		Options: S3Options{EventTime: &EventTime{Field: "ts", Fallback: "unknown"}},
+		since:   now.Add(-time.Minute),
+	}
+	data := []byte(`{"ts": "2017-08-03T23:59:50Z"}` + "\n" +
+		`{"ts": "2017-08-04T00:00:05Z"}` + "\n" +
+		`{"ts": "2017-08-03T23:59:30Z"}` + "\n" +
+		`{"message": "no ts"}` + "\n")
+
+	partitions := sl.partitions(data, now)
+	if len(partitions) != 3 {
+		t.Fatalf("unexpected partitions: %d", len(partitions))
+	}
+	fallback, before, after := partitions[0], partitions[1], partitions[2]
+	if before.prefix != "testresult/year=2017/month=8/day=3/" || len(before.data) != 62 ||
+		before.first.Second() != 30 || before.last.Second() != 50 {
+		t.Fatalf("unexpected partition before midnight: %+v", before)
+	}
+	if after.prefix != "testresult/year=2017/month=8/day=4/" {
+		t.Fatalf("unexpected partition after midnight: %+v", after)
+	}
+	if fallback.prefix != "testresult/unknown/" || string(fallback.data) != `{"message": "no ts"}`+"\n" {
+		t.Fatalf("unexpected fallback partition: %+v", fallback)
+	}
+
+	// without the fallback the records without a timestamp share the partition of the upload time
+	sl.Options.EventTime.Fallback = ""
+	untimed := []byte(`{"message": "no ts"}` + "\n" +
+		`{"ts": "2017-08-04T00:00:05Z"}` + "\n" +
+		`{"ts": "2017-08-04T00:00:02Z"}` + "\n")
+	if partitions := sl.partitions(untimed, now); len(partitions) != 1 ||
+		partitions[0].first.Second() != 2 || partitions[0].last.Second() != 5 {
+		t.Fatalf("event times are taken from the record without a timestamp: %+v", partitions[0])
+	}
+
+	sl.Options.EventTime = nil
+	if partitions := sl.partitions(data, now); len(partitions) != 1 || partitions[0].prefix != after.prefix {
+		t.Fatalf("upload time is not the partition: %+v", partitions)
+	}
+}
@@ -0,0 +1,63 @@
+package lake
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// Progress records the processed objects in a file to resume the work
+type Progress struct {
+	file  *os.File
+	mutex *sync.Mutex
+	done  map[string]bool
+}
+
+// OpenProgress reads the processed objects of the progress file
+func OpenProgress(path string) (*Progress, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		done[scanner.Text()] = true
+	}
+	if err := scanner.Err(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	progress := &Progress{
+		file:  f,
+		mutex: &sync.Mutex{},
+		done:  done,
+	}
+	return progress, nil
+}
+
+// Done reports whether the object is processed
+func (p *Progress) Done(key string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.done[key]
+}
+
+// Mark records the object as processed
+func (p *Progress) Mark(key string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, err := p.file.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	p.done[key] = true
+	return p.file.Sync()
+}
+
+// Close closes the progress file
+func (p *Progress) Close() error {
+	return p.file.Close()
+}
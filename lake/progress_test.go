@@ -0,0 +1,35 @@
+package lake
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	progressPath := path.Join(dir, "replay.progress")
+
+	progress, err := OpenProgress(progressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := progress.Mark("a/year=2017/month=8/day=3/host-1:2.txt.gz"); err != nil {
+		t.Fatal(err)
+	}
+	_ = progress.Close()
+
+	resumed, err := OpenProgress(progressPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	if !resumed.Done("a/year=2017/month=8/day=3/host-1:2.txt.gz") || resumed.Done("b") {
+		t.Fatal("progress is not resumed")
+	}
+}
@@ -0,0 +1,172 @@
+package lake
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/findcoo/s4/crypt"
+)
+
+var (
+	partitionPattern = regexp.MustCompile(`year=(\d{4})/month=(\d{1,2})/day=(\d{1,2})/(?:.*-(\d{1,2}):(\d{1,2})(?:-\d+)?\.)?`)
+)
+
+// Object an object of the lake
+type Object struct {
+	Key          string
+	Time         time.Time
+	Size         int64
+	StorageClass string
+}
+
+// Partition returns the day partition of the object, empty when its key is not partitioned
+func (o *Object) Partition() string {
+	if _, ok := PartitionTime(o.Key); !ok {
+		return ""
+	}
+	return dayPartition(o.Time)
+}
+
+// S3Reader reads the objects written by S3Supplyer
+type S3Reader struct {
+	Bucket string
+	Key    string
+	// Keyring decrypts the objects encrypted on the client side
+	Keyring *crypt.Keyring
+	client  *s3.S3
+}
+
+// OpenS3Reader returns a S3Reader
+func OpenS3Reader(region, bucket, key string) (*S3Reader, error) {
+	client, err := newS3Client(region)
+	if err != nil {
+		return nil, err
+	}
+	reader := &S3Reader{
+		Bucket: bucket,
+		Key:    key,
+		client: client,
+	}
+	return reader, nil
+}
+
+// PartitionTime parses the time of an object from the partition path,
+// the time of the day is zero when the object name has no hour and minute
+func PartitionTime(key string) (time.Time, bool) {
+	match := partitionPattern.FindStringSubmatch(key)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	fields := make([]int, 5)
+	for i, field := range match[1:] {
+		fields[i], _ = strconv.Atoi(field)
+	}
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], 0, 0, time.Local)
+	return t, true
+}
+
+// List lists the objects whose partition time is in [from, to) in order of the time,
+// a zero from or to is unbounded
+func (r *S3Reader) List(from, to time.Time) ([]*Object, error) {
+	var prefixes []string
+	if from.IsZero() || to.IsZero() {
+		prefixes = append(prefixes, r.Key+"/")
+	} else {
+		day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+		for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+			prefixes = append(prefixes, partitionPrefix(r.Key, day))
+		}
+	}
+
+	var objects []*Object
+	for _, prefix := range prefixes {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(r.Bucket),
+			Prefix: aws.String(prefix),
+		}
+		err := r.client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+			for _, content := range page.Contents {
+				key := aws.StringValue(content.Key)
+				t, ok := PartitionTime(key)
+				if !ok || (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+					continue
+				}
+				objects = append(objects, &Object{
+					Key:          key,
+					Time:         t,
+					Size:         aws.Int64Value(content.Size),
+					StorageClass: aws.StringValue(content.StorageClass),
+				})
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(objects, func(i, j int) bool {
+		return objects[i].Time.Before(objects[j].Time)
+	})
+	return objects, nil
+}
+
+// Decrypt opens the object encrypted on the client side by the wrapped data key of the metadata,
+// the object without the data key is returned as it is
+func Decrypt(keyring *crypt.Keyring, body []byte, metadata map[string]*string) ([]byte, error) {
+	for name, value := range metadata {
+		if !strings.EqualFold(name, MetaDataKey) {
+			continue
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(aws.StringValue(value))
+		if err != nil {
+			return nil, err
+		}
+		return keyring.OpenEnvelope(&crypt.Envelope{WrappedKey: wrapped, Ciphertext: body})
+	}
+	return body, nil
+}
+
+// Read downloads, decrypts and decompresses the object
+func (r *S3Reader) Read(key string) ([]byte, error) {
+	data, _, err := r.read(key)
+	return data, err
+}
+
+// read returns the records and the ETag of the object
+func (r *S3Reader) read(key string) ([]byte, string, error) {
+	output, err := r.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(r.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer output.Body.Close()
+
+	body, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	compressed, err := Decrypt(r.Keyring, body, output.Metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, "", err
+	}
+	defer gzr.Close()
+	data, err := ioutil.ReadAll(gzr)
+	return data, aws.StringValue(output.ETag), err
+}
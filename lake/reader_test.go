@@ -0,0 +1,51 @@
+package lake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionTime(t *testing.T) {
+	now := time.Date(2017, 8, 3, 14, 5, 0, 0, time.Local)
+	key := partitionPrefix("testresult", now) + "host-name-14:5.txt.gz"
+
+	parsed, ok := PartitionTime(key)
+	if !ok || !parsed.Equal(now) {
+		t.Fatalf("unexpected time of %s: %s", key, parsed)
+	}
+
+	if parsed, ok := PartitionTime(partitionPrefix("testresult", now) + "host-name-14:5-12.txt.gz"); !ok || !parsed.Equal(now) {
+		t.Fatalf("unexpected time of the suffixed key: %s", parsed)
+	}
+
+	parsed, ok = PartitionTime("testresult/year=2017/month=8/day=3/compacted.txt.gz")
+	if !ok || !parsed.Equal(time.Date(2017, 8, 3, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected time of the day partition: %s", parsed)
+	}
+
+	if _, ok := PartitionTime("testresult/other.txt.gz"); ok {
+		t.Fatal("key without partition must not be parsed")
+	}
+
+	object := &Object{Key: key, Time: now}
+	if partition := object.Partition(); partition != "year=2017/month=8/day=3" {
+		t.Fatalf("unexpected partition of %s: %s", key, partition)
+	}
+	if partition := (&Object{Key: "testresult/other.txt.gz"}).Partition(); partition != "" {
+		t.Fatalf("unexpected partition of the key without partition: %s", partition)
+	}
+}
+
+func TestS3Reader(t *testing.T) {
+	reader, err := OpenS3Reader("ap-northeast-2", "test.quicket.s4", "testresult")
+	if err != nil {
+		t.Skip(err)
+	}
+	objects, err := reader.List(time.Now().AddDate(0, 0, -1), time.Now())
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, object := range objects {
+		t.Log(object.Key)
+	}
+}
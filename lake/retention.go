@@ -0,0 +1,126 @@
+package lake
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/findcoo/s4/logger"
+)
+
+// Retention deletes or transitions the objects of the partitions older than the period
+type Retention struct {
+	Reader *S3Reader
+	Period time.Duration
+	// StorageClass transitions the objects to the class instead of deleting them
+	StorageClass string
+	// Options server-side encryption of the transitioned objects
+	Options S3Options
+	// DryRun lists the expired objects without changing them
+	DryRun bool
+}
+
+// NewRetention returns a Retention
+func NewRetention(region, bucket, key string, period time.Duration) (*Retention, error) {
+	reader, err := OpenS3Reader(region, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	retention := &Retention{
+		Reader: reader,
+		Period: period,
+	}
+	return retention, nil
+}
+
+// cutoff returns the first day kept at the time, a partition expires as a whole day
+func (r *Retention) cutoff(now time.Time) time.Time {
+	t := now.Add(-r.Period)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Expired lists the objects of the days before the cutoff,
+// the objects already in the storage class of the transition are left out
+func (r *Retention) Expired(now time.Time) ([]*Object, error) {
+	objects, err := r.Reader.List(time.Time{}, r.cutoff(now))
+	if err != nil {
+		return nil, err
+	}
+	if r.StorageClass == "" {
+		return objects, nil
+	}
+
+	var expired []*Object
+	for _, object := range objects {
+		if object.StorageClass != r.StorageClass {
+			expired = append(expired, object)
+		}
+	}
+	return expired, nil
+}
+
+// Run deletes or transitions the expired objects, it returns the affected objects
+func (r *Retention) Run(now time.Time) ([]*Object, error) {
+	objects, err := r.Expired(now)
+	if err != nil || r.DryRun {
+		return objects, err
+	}
+
+	for i, object := range objects {
+		if err := r.apply(object); err != nil {
+			return objects[:i], err
+		}
+	}
+	return objects, nil
+}
+
+func (r *Retention) apply(object *Object) error {
+	client, bucket := r.Reader.client, r.Reader.Bucket
+	if r.StorageClass == "" {
+		_, err := client.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object.Key),
+		})
+		return err
+	}
+
+	// an object is transitioned by a copy onto itself keeping the metadata
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		CopySource:        aws.String(url.PathEscape(bucket + "/" + object.Key)),
+		Key:               aws.String(object.Key),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+		StorageClass:      aws.String(r.StorageClass),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = r.Options.serverSideEncryption()
+	_, err := client.CopyObject(input)
+	return err
+}
+
+// Schedule runs the retention every interval until the returned function is called
+func (r *Retention) Schedule(interval time.Duration) func() {
+	stop := make(chan struct{})
+	log := logger.With("bucket", r.Reader.Bucket, "key", r.Reader.Key)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			objects, err := r.Run(time.Now())
+			if err != nil {
+				log.Errorf("Retention: %v", err)
+			} else if len(objects) > 0 {
+				log.Infof("Retention: %d objects expired", len(objects))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
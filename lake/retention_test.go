@@ -0,0 +1,22 @@
+package lake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionCutoff(t *testing.T) {
+	retention := &Retention{Period: 48 * time.Hour}
+	now := time.Date(2017, 8, 10, 14, 5, 0, 0, time.Local)
+	cutoff := retention.cutoff(now)
+	if !cutoff.Equal(time.Date(2017, 8, 8, 0, 0, 0, 0, time.Local)) {
+		t.Fatalf("unexpected cutoff: %s", cutoff)
+	}
+
+	// the objects of the cutoff day are kept as a whole
+	kept, _ := PartitionTime(partitionPrefix("testresult", cutoff) + "host-" + contentHash([]byte("a\n")) + ".txt.gz")
+	expired, _ := PartitionTime(partitionPrefix("testresult", cutoff.AddDate(0, 0, -1)) + "host-23:59.txt.gz")
+	if kept.Before(cutoff) || !expired.Before(cutoff) {
+		t.Fatalf("unexpected expiry: kept %s, expired %s", kept, expired)
+	}
+}
@@ -0,0 +1,147 @@
+package lake
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// Batch a flush of a river with its descriptor
+type Batch struct {
+	Data    []byte
+	Records int
+	// Source identity of the buffer of the records, empty when the river has no buffer path
+	Source string
+	// FirstOffset and LastOffset of the records in the buffer, zero when the river does not know them
+	FirstOffset uint64
+	LastOffset  uint64
+	// Partition hint, a prefix under the key of the sink replacing the partition of the upload time
+	Partition string
+	// Name hint, the name of the objects under their partitions replacing the name of the upload time
+	Name string
+	// uploaded objects of the partitions written by the s3 sinks, the batch pushed again skips them
+	uploaded map[string]uploadedObject
+}
+
+type uploadedObject struct {
+	key  string
+	size int
+}
+
+// NewBatch returns the Batch of the newline-delimited records
+func NewBatch(data []byte) *Batch {
+	batch := &Batch{
+		Data:    data,
+		Records: bytes.Count(data, []byte("\n")),
+	}
+	return batch
+}
+
+// PartitionAt returns the partition of the objects of the batch pushed at the time,
+// the partition hint or the day partition of the time
+func (b *Batch) PartitionAt(t time.Time) string {
+	if b.Partition != "" {
+		return strings.Trim(b.Partition, "/")
+	}
+	return dayPartition(t)
+}
+
+// Sink data-lake interface with a deadline and the descriptor of the batch
+type Sink interface {
+	Write(ctx context.Context, batch *Batch) error
+	// Flush writes out what the sink holds
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// SupplyerSink adapts a Supplyer to the Sink
+type SupplyerSink struct {
+	Supplyer
+}
+
+// Write pushes the data of the batch unless the context is done
+func (ss *SupplyerSink) Write(ctx context.Context, batch *Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ss.Supplyer.Push(batch.Data)
+}
+
+// Flush nothing is held by a Supplyer
+func (ss *SupplyerSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close closes the Supplyer if it is an io.Closer
+func (ss *SupplyerSink) Close() error {
+	if closer, ok := ss.Supplyer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SinkSupplyer adapts a Sink to the Supplyer of the rivers
+type SinkSupplyer struct {
+	Sink
+}
+
+// Push writes the data as a batch
+func (ss *SinkSupplyer) Push(data []byte) error {
+	return ss.Sink.Write(context.Background(), NewBatch(data))
+}
+
+// AsSink returns the Sink of the Supplyer, adapting it when it is not a Sink
+func AsSink(s Supplyer) Sink {
+	if sink, ok := s.(Sink); ok {
+		return sink
+	}
+	return &SupplyerSink{Supplyer: s}
+}
+
+// PushBatch writes the batch to the Supplyer that is a Sink, or pushes its data
+func PushBatch(ctx context.Context, s Supplyer, batch *Batch) error {
+	return AsSink(s).Write(ctx, batch)
+}
+
+// MultiSink writes to every sink
+type MultiSink []Sink
+
+// Write writes the batch to every sink, returns the first error
+func (ms MultiSink) Write(ctx context.Context, batch *Batch) error {
+	var first error
+	for _, s := range ms {
+		if err := s.Write(ctx, batch); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Flush flushes every sink, returns the first error
+func (ms MultiSink) Flush(ctx context.Context) error {
+	var first error
+	for _, s := range ms {
+		if err := s.Flush(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close closes every sink, returns the first error
+func (ms MultiSink) Close() error {
+	var first error
+	for _, s := range ms {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Push writes the data as a batch to every sink
+func (ms MultiSink) Push(data []byte) error {
+	return ms.Write(context.Background(), NewBatch(data))
+}
@@ -0,0 +1,70 @@
+package lake
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type captureCloser struct {
+	captureSupplyer
+	closed bool
+}
+
+func (cc *captureCloser) Close() error {
+	cc.closed = true
+	return nil
+}
+
+func TestSupplyerSink(t *testing.T) {
+	supplyer := &captureCloser{}
+	sink := AsSink(supplyer)
+	if err := sink.Write(context.Background(), NewBatch([]byte("a\nb\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if string(supplyer.data) != "a\nb\n" {
+		t.Fatalf("unexpected pushes: %q", supplyer.data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sink.Write(ctx, NewBatch([]byte("c\n"))); err != context.Canceled || string(supplyer.data) != "a\nb\n" {
+		t.Fatalf("canceled batch is pushed: %v", err)
+	}
+
+	if err := sink.Close(); err != nil || !supplyer.closed {
+		t.Fatal("supplyer is not closed")
+	}
+}
+
+func TestSinkSupplyer(t *testing.T) {
+	console := NewConsoleSupplyer()
+	if sink := AsSink(console); sink != Sink(console) {
+		t.Fatal("console must be a sink without the adapter")
+	}
+
+	first, second := &captureSupplyer{}, &captureSupplyer{}
+	multi := MultiSink{AsSink(first), AsSink(second)}
+	supplyer := &SinkSupplyer{Sink: multi}
+	if err := supplyer.Push([]byte("a\n")); err != nil {
+		t.Fatal(err)
+	}
+	if string(first.data) != "a\n" || string(second.data) != "a\n" {
+		t.Fatal("batch is not written to every sink")
+	}
+	if batch := NewBatch([]byte("a\nb\nc\n")); batch.Records != 3 {
+		t.Fatalf("unexpected records: %d", batch.Records)
+	}
+}
+
+func TestBatchPartition(t *testing.T) {
+	at := time.Date(2017, 8, 3, 23, 59, 0, 0, time.UTC)
+	batch := NewBatch([]byte("a\n"))
+	if partition := batch.PartitionAt(at); partition != "year=2017/month=8/day=3" {
+		t.Fatalf("unexpected partition of the time: %s", partition)
+	}
+	batch.Partition = "/year=2017/month=8/day=1/"
+	if partition := batch.PartitionAt(at); partition != "year=2017/month=8/day=1" {
+		t.Fatalf("unexpected partition of the hint: %s", partition)
+	}
+}
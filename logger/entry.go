@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// formats of the logs
+const (
+	// FormatText the message followed by the fields, the standard logger adds the time
+	FormatText   = "text"
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+var (
+	// ErrUnknownFormat unknown format of the logs
+	ErrUnknownFormat = errors.New("unknown log format")
+
+	format atomic.Value
+	root   = &Entry{}
+)
+
+// SetFormat sets the format of the logs, text, json or logfmt
+func SetFormat(name string) error {
+	switch name {
+	case FormatText, FormatJSON, FormatLogfmt:
+		format.Store(name)
+		return nil
+	}
+	return ErrUnknownFormat
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Entry logs with the fields, a nil Entry logs without fields
+type Entry struct {
+	fields []field
+}
+
+// With returns an Entry with the fields of the key and value pairs
+func With(kv ...interface{}) *Entry {
+	return root.With(kv...)
+}
+
+// With returns a copy of the Entry with the fields of the key and value pairs added
+func (e *Entry) With(kv ...interface{}) *Entry {
+	var fields []field
+	if e != nil {
+		fields = append(fields, e.fields...)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, field{key: fmt.Sprint(kv[i]), value: kv[i+1]})
+	}
+	return &Entry{fields: fields}
+}
+
+// Print logs in the manner of fmt.Print at the info level
+func (e *Entry) Print(v ...interface{}) {
+	e.output(3, LevelInfo, fmt.Sprint(v...))
+}
+
+// Printf logs in the manner of fmt.Printf at the info level
+func (e *Entry) Printf(format string, v ...interface{}) {
+	e.output(3, LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Debugf logs at the debug level
+func (e *Entry) Debugf(format string, v ...interface{}) {
+	e.output(3, LevelDebug, fmt.Sprintf(format, v...))
+}
+
+// Infof logs at the info level
+func (e *Entry) Infof(format string, v ...interface{}) {
+	e.output(3, LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Warnf logs at the warn level
+func (e *Entry) Warnf(format string, v ...interface{}) {
+	e.output(3, LevelWarn, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs at the error level
+func (e *Entry) Errorf(format string, v ...interface{}) {
+	e.output(3, LevelError, fmt.Sprintf(format, v...))
+}
+
+func (e *Entry) output(calldepth int, l Level, msg string) {
+	if !Enabled(l) {
+		return
+	}
+	var fields []field
+	if e != nil {
+		fields = e.fields
+	}
+	_ = Get().Output(calldepth, encode(format.Load().(string), time.Now(), l, msg, fields))
+}
+
+// encode formats a log in the format
+func encode(name string, t time.Time, l Level, msg string, fields []field) string {
+	var buf bytes.Buffer
+	switch name {
+	case FormatJSON:
+		buf.WriteString(`{"time":`)
+		writeJSON(&buf, t.Format(time.RFC3339Nano))
+		buf.WriteString(`,"level":`)
+		writeJSON(&buf, l.String())
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, msg)
+		for _, f := range fields {
+			buf.WriteByte(',')
+			writeJSON(&buf, f.key)
+			buf.WriteByte(':')
+			writeJSON(&buf, f.value)
+		}
+		buf.WriteByte('}')
+	case FormatLogfmt:
+		buf.WriteString("time=")
+		buf.WriteString(t.Format(time.RFC3339Nano))
+		buf.WriteString(" level=")
+		buf.WriteString(l.String())
+		buf.WriteString(" msg=")
+		buf.WriteString(logfmtValue(msg))
+		for _, f := range fields {
+			buf.WriteByte(' ')
+			buf.WriteString(f.key)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(fmt.Sprint(f.value)))
+		}
+	default:
+		if l != LevelInfo {
+			buf.WriteString("[" + l.String() + "] ")
+		}
+		buf.WriteString(msg)
+		for _, f := range fields {
+			buf.WriteByte(' ')
+			buf.WriteString(f.key)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(fmt.Sprint(f.value)))
+		}
+	}
+	return buf.String()
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(encoded)
+}
+
+// logfmtValue quotes the value with a space, a quote or an equal sign
+func logfmtValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \"=\t\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
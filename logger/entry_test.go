@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestWith(t *testing.T) {
+	var buf bytes.Buffer
+	Set(log.New(&buf, "", 0))
+	defer Set(nil)
+
+	pipeline := With("pipeline", "app")
+	pipeline.With("conn", 3).Infof("Connect to the waterhead")
+	pipeline.Warnf("buffer is full")
+	if buf.String() != "Connect to the waterhead pipeline=app conn=3\n[warn] buffer is full pipeline=app\n" {
+		t.Fatalf("unexpected logs: %q", buf.String())
+	}
+
+	buf.Reset()
+	var entry *Entry
+	entry.Printf("no fields")
+	if buf.String() != "no fields\n" {
+		t.Fatalf("unexpected logs: %q", buf.String())
+	}
+}
+
+func TestEncode(t *testing.T) {
+	now := time.Date(2017, 8, 1, 12, 0, 0, 0, time.UTC)
+	fields := []field{{"pipeline", "app log"}, {"last_offset", uint64(42)}, {"err", errors.New("closed")}}
+
+	line := encode(FormatLogfmt, now, LevelError, "Push the batch", fields)
+	expected := `time=2017-08-01T12:00:00Z level=error msg="Push the batch" pipeline="app log" last_offset=42 err=closed`
+	if line != expected {
+		t.Fatalf("unexpected logfmt: %s", line)
+	}
+
+	line = encode(FormatJSON, now, LevelInfo, "Push the batch", fields)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["level"] != "info" || decoded["msg"] != "Push the batch" || decoded["pipeline"] != "app log" ||
+		decoded["last_offset"] != float64(42) || decoded["err"] != "closed" {
+		t.Fatalf("unexpected json: %s", line)
+	}
+
+	if err := SetFormat("xml"); err != ErrUnknownFormat {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
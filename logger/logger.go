@@ -0,0 +1,125 @@
+// Package logger routes the leveled, structured logs of s4 to the standard logger or to the logger of the host process
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level severity of a log
+type Level int32
+
+// levels of the logs
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	// ErrUnknownLevel unknown name of a level
+	ErrUnknownLevel = errors.New("unknown log level")
+
+	levelNames = []string{"debug", "info", "warn", "error"}
+)
+
+// String returns the name of the level
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the name, debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, ErrUnknownLevel
+}
+
+// Logger receives the logs of s4, *log.Logger is a Logger
+type Logger interface {
+	Output(calldepth int, s string) error
+}
+
+type stdLogger struct{}
+
+// Output writes through the standard logger so that its output and flags apply
+func (stdLogger) Output(calldepth int, s string) error {
+	return log.Output(calldepth+1, s)
+}
+
+type holder struct {
+	Logger
+}
+
+var (
+	current atomic.Value
+	level   = int32(LevelInfo)
+)
+
+func init() {
+	current.Store(holder{stdLogger{}})
+	format.Store(FormatText)
+}
+
+// Set replaces the logger of s4, nil restores the standard logger
+func Set(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	current.Store(holder{l})
+}
+
+// Get returns the logger of s4
+func Get() Logger {
+	return current.Load().(holder).Logger
+}
+
+// SetLevel drops the logs below the level
+func SetLevel(l Level) {
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// Enabled reports whether the logs of the level are written
+func Enabled(l Level) bool {
+	return l >= Level(atomic.LoadInt32(&level))
+}
+
+// Print logs in the manner of fmt.Print at the info level
+func Print(v ...interface{}) {
+	root.output(3, LevelInfo, fmt.Sprint(v...))
+}
+
+// Printf logs in the manner of fmt.Printf at the info level
+func Printf(format string, v ...interface{}) {
+	root.output(3, LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Debugf logs at the debug level
+func Debugf(format string, v ...interface{}) {
+	root.output(3, LevelDebug, fmt.Sprintf(format, v...))
+}
+
+// Infof logs at the info level
+func Infof(format string, v ...interface{}) {
+	root.output(3, LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Warnf logs at the warn level
+func Warnf(format string, v ...interface{}) {
+	root.output(3, LevelWarn, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs at the error level
+func Errorf(format string, v ...interface{}) {
+	root.output(3, LevelError, fmt.Sprintf(format, v...))
+}
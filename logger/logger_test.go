@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	var buf bytes.Buffer
+	Set(log.New(&buf, "host ", 0))
+	defer Set(nil)
+
+	Printf("flushed %d bytes", 10)
+	Print("done")
+	if buf.String() != "host flushed 10 bytes\nhost done\n" {
+		t.Fatalf("unexpected logs: %q", buf.String())
+	}
+
+	Set(nil)
+	if _, ok := Get().(stdLogger); !ok {
+		t.Fatal("nil must restore the standard logger")
+	}
+}
+
+func TestLevel(t *testing.T) {
+	var buf bytes.Buffer
+	Set(log.New(&buf, "", 0))
+	defer Set(nil)
+	defer SetLevel(LevelInfo)
+
+	level, err := ParseLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Fatalf("unexpected level: %v %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err != ErrUnknownLevel {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	SetLevel(level)
+	Debugf("debug")
+	Infof("info")
+	Warnf("warn")
+	Errorf("error")
+	if buf.String() != "[warn] warn\n[error] error\n" {
+		t.Fatalf("unexpected logs: %q", buf.String())
+	}
+}
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"errors"
+	_ "expvar"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"path"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/findcoo/s4/config"
 	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/pipeline"
+	"github.com/findcoo/s4/process"
 	"github.com/findcoo/s4/river"
 	"github.com/findcoo/s4/test"
 	"github.com/urfave/cli"
@@ -17,7 +26,9 @@ import (
 var (
 	// ErrOptionRequired require option
 	ErrOptionRequired = errors.New("some options required, check up help")
-	s3ConfigFlag      = []cli.Flag{
+	// ErrMalformedPair the flag is not "key=value"
+	ErrMalformedPair = errors.New("malformed key=value")
+	s3ConfigFlag     = []cli.Flag{
 		cli.StringFlag{
 			Name:   "s3Path, s",
 			Usage:  "s3 path, required",
@@ -28,6 +39,78 @@ var (
 			Usage:  "aws s3 region, required",
 			EnvVar: "S4_REGION",
 		},
+		cli.StringFlag{
+			Name:   "sse",
+			Usage:  "server-side encryption of the objects(sse-s3, sse-kms)",
+			EnvVar: "S4_SSE",
+		},
+		cli.StringFlag{
+			Name:   "sse-kms-key-id",
+			Usage:  "kms key of the sse-kms, the aws managed key by default",
+			EnvVar: "S4_SSE_KMS_KEY_ID",
+		},
+		cli.StringFlag{
+			Name:   "storage-class",
+			Usage:  "storage class of the objects, e.g. STANDARD_IA",
+			EnvVar: "S4_STORAGE_CLASS",
+		},
+		cli.StringFlag{
+			Name:   "acl",
+			Usage:  "canned ACL of the objects, e.g. bucket-owner-full-control",
+			EnvVar: "S4_ACL",
+		},
+		cli.StringSliceFlag{
+			Name:   "tag",
+			Usage:  "tag of the objects, \"key=value\"",
+			EnvVar: "S4_TAG",
+		},
+		cli.StringSliceFlag{
+			Name:   "metadata",
+			Usage:  "custom metadata of the objects, \"key=value\"",
+			EnvVar: "S4_METADATA",
+		},
+		envelopeKeyFlag,
+		cli.StringFlag{
+			Name:   "event-time-field",
+			Usage:  "partition the json records by the timestamp of the field instead of the upload time",
+			EnvVar: "S4_EVENT_TIME_FIELD",
+		},
+		cli.StringFlag{
+			Name:   "event-time-format",
+			Value:  lake.FormatRFC3339,
+			Usage:  "format of the event time(rfc3339, unix, unix_ms or a go time layout)",
+			EnvVar: "S4_EVENT_TIME_FORMAT",
+		},
+		cli.BoolFlag{
+			Name:   "idempotent",
+			Usage:  "name the objects by the hash of the records and skip the uploaded ones",
+			EnvVar: "S4_IDEMPOTENT",
+		},
+		cli.BoolFlag{
+			Name:   "manifest",
+			Usage:  "write a manifest of the objects of each flush under the _manifests prefix",
+			EnvVar: "S4_MANIFEST",
+		},
+		cli.StringFlag{
+			Name:   "catalog",
+			Usage:  "path of the local catalog of the partitions for s4 catalog export",
+			EnvVar: "S4_CATALOG",
+		},
+		cli.StringFlag{
+			Name:   "table",
+			Usage:  "table name of the catalog, the last element of the s3 path by default",
+			EnvVar: "S4_TABLE",
+		},
+		cli.StringFlag{
+			Name:   "event-time-fallback",
+			Usage:  "prefix of the records without a valid event time, the upload time partition by default",
+			EnvVar: "S4_EVENT_TIME_FALLBACK",
+		},
+	}
+	envelopeKeyFlag = cli.StringSliceFlag{
+		Name:   "envelope-key",
+		Usage:  "encrypt the objects on the client side with the master key \"id=file:/path\" or \"id=env:VARIABLE\", the first key encrypts",
+		EnvVar: "S4_ENVELOPE_KEY",
 	}
 	bufferConfigFlag = []cli.Flag{
 		cli.StringFlag{
@@ -50,93 +133,383 @@ var (
 		cli.StringFlag{
 			Name:   "type, t",
 			Value:  "line",
-			Usage:  "define the buffer type that can be parsed format(json, line)",
+			Usage:  "define the buffer type that can be parsed format(json, line, memory)",
 			EnvVar: "S4_RIVER_TYPE",
 		},
+		cli.Int64Flag{
+			Name:   "max-buffer",
+			Usage:  "maximum bytes of the buffer, 0 is unlimited",
+			EnvVar: "S4_MAX_BUFFER",
+		},
+		cli.StringFlag{
+			Name:   "overflow",
+			Usage:  "policy when the buffer is full(block, drop-newest, drop-oldest), default block or drop-oldest on the memory type",
+			EnvVar: "S4_OVERFLOW",
+		},
+		cli.Int64Flag{
+			Name:   "segment-size",
+			Value:  64 << 20,
+			Usage:  "roll the segment of the line buffer over the bytes",
+			EnvVar: "S4_SEGMENT_SIZE",
+		},
+		cli.DurationFlag{
+			Name:   "segment-age",
+			Usage:  "roll the segment of the line buffer older than the duration, 0 rolls at each flush",
+			EnvVar: "S4_SEGMENT_AGE",
+		},
+		cli.StringFlag{
+			Name:   "durability",
+			Value:  river.DurabilityNone,
+			Usage:  "fsync policy of the buffer(none, interval, always)",
+			EnvVar: "S4_DURABILITY",
+		},
+		cli.DurationFlag{
+			Name:   "sync-interval",
+			Value:  time.Millisecond * 100,
+			Usage:  "fsync interval of the interval durability",
+			EnvVar: "S4_SYNC_INTERVAL",
+		},
+		bufferKeyFlag,
+	}
+	uploadConfigFlag = []cli.Flag{
+		cli.IntFlag{
+			Name:   "upload-concurrency",
+			Usage:  "workers pushing the batches, 0 pushes them in the consumer",
+			EnvVar: "S4_UPLOAD_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   "upload-queue",
+			Usage:  "batches waiting for the workers before the consumer blocks, the concurrency by default",
+			EnvVar: "S4_UPLOAD_QUEUE",
+		},
+		cli.BoolFlag{
+			Name:   "upload-ordered",
+			Usage:  "push the batches of a partition one by one in order",
+			EnvVar: "S4_UPLOAD_ORDERED",
+		},
+		cli.DurationFlag{
+			Name:   "upload-timeout",
+			Usage:  "timeout of each object uploaded to s3, 0 waits",
+			EnvVar: "S4_UPLOAD_TIMEOUT",
+		},
+	}
+	retentionScheduleFlag = []cli.Flag{
+		cli.DurationFlag{
+			Name:   "retention-period",
+			Usage:  "delete or transition the partitions older than the period, 0 keeps them",
+			EnvVar: "S4_RETENTION_PERIOD",
+		},
+		cli.StringFlag{
+			Name:   "retention-storage-class",
+			Usage:  "transition the expired objects to the storage class instead of deleting them",
+			EnvVar: "S4_RETENTION_STORAGE_CLASS",
+		},
+		cli.DurationFlag{
+			Name:   "retention-interval",
+			Value:  24 * time.Hour,
+			Usage:  "interval of the retention while the server runs",
+			EnvVar: "S4_RETENTION_INTERVAL",
+		},
+	}
+	reconnectConfigFlag = []cli.Flag{
+		cli.BoolFlag{
+			Name:   "reconnect",
+			Usage:  "dial the socket again with an exponential backoff when the connection ends",
+			EnvVar: "S4_RECONNECT",
+		},
+		cli.IntFlag{
+			Name:   "reconnect-retries",
+			Usage:  "failed dials in a row before the client exits, 0 retries forever",
+			EnvVar: "S4_RECONNECT_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "reconnect-backoff",
+			Value:  100 * time.Millisecond,
+			Usage:  "first delay of the reconnection, doubled after each failed dial",
+			EnvVar: "S4_RECONNECT_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "reconnect-max-backoff",
+			Value:  30 * time.Second,
+			Usage:  "upper bound of the delay of the reconnection",
+			EnvVar: "S4_RECONNECT_MAX_BACKOFF",
+		},
+	}
+	bufferKeyFlag = cli.StringSliceFlag{
+		Name:   "buffer-key",
+		Usage:  "encrypt the buffer with the key \"id=file:/path\" or \"id=env:VARIABLE\", the first key encrypts and the others decrypt",
+		EnvVar: "S4_BUFFER_KEY",
+	}
+	processConfigFlag = []cli.Flag{
+		cli.StringSliceFlag{
+			Name:   "redact",
+			Usage:  "mask the matches of a builtin rule(email, card, token) or a custom \"name=regexp\" rule",
+			EnvVar: "S4_REDACT",
+		},
+		cli.StringSliceFlag{
+			Name:   "redact-field",
+			Usage:  "hash or remove a field of the json records, \"path.to.field=hash|remove\"",
+			EnvVar: "S4_REDACT_FIELD",
+		},
+		cli.Float64Flag{
+			Name:   "rate-records",
+			Usage:  "records per second of the river, 0 is unlimited",
+			EnvVar: "S4_RATE_RECORDS",
+		},
+		cli.Float64Flag{
+			Name:   "rate-bytes",
+			Usage:  "bytes per second of the river, 0 is unlimited",
+			EnvVar: "S4_RATE_BYTES",
+		},
+		cli.Float64Flag{
+			Name:   "conn-rate-records",
+			Usage:  "records per second of each connection, 0 is unlimited",
+			EnvVar: "S4_CONN_RATE_RECORDS",
+		},
+		cli.Float64Flag{
+			Name:   "conn-rate-bytes",
+			Usage:  "bytes per second of each connection, 0 is unlimited",
+			EnvVar: "S4_CONN_RATE_BYTES",
+		},
+		cli.StringFlag{
+			Name:   "rate-policy",
+			Value:  process.PolicyBlock,
+			Usage:  "block the sender or drop the records when the rate is exceeded(block, drop)",
+			EnvVar: "S4_RATE_POLICY",
+		},
+		cli.Float64Flag{
+			Name:   "sample",
+			Value:  1,
+			Usage:  "fraction of the records to keep(0 to 1)",
+			EnvVar: "S4_SAMPLE",
+		},
+		cli.StringFlag{
+			Name:   "stamp",
+			Usage:  "add the ingest time, hostname, input, connection id and sequence to the records(fields for json, prefix or json for line)",
+			EnvVar: "S4_STAMP",
+		},
+		cli.StringFlag{
+			Name:   "sample-key",
+			Usage:  "json field path whose hash decides the sampling instead of randomness",
+			EnvVar: "S4_SAMPLE_KEY",
+		},
 	}
 )
 
-func optionParser(c *cli.Context) (*river.Config, error) {
+func processOptions(c *cli.Context) config.Processors {
+	policy := c.String("rate-policy")
+	if policy == "" {
+		policy = process.PolicyBlock
+	}
+
+	processors := config.Processors{
+		Redact:       c.StringSlice("redact"),
+		RedactFields: c.StringSlice("redact-field"),
+		Sample:       c.Float64("sample"),
+		SampleKey:    c.String("sample-key"),
+		Stamp:        c.String("stamp"),
+		Rate: config.Rate{
+			Records: c.Float64("rate-records"),
+			Bytes:   c.Float64("rate-bytes"),
+			Policy:  policy,
+		},
+		ConnRate: config.Rate{
+			Records: c.Float64("conn-rate-records"),
+			Bytes:   c.Float64("conn-rate-bytes"),
+			Policy:  policy,
+		},
+	}
+	return processors
+}
+
+// keyValues parses the "key=value" flags
+func keyValues(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	pairs := make(map[string]string, len(values))
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q: %v", value, ErrMalformedPair)
+		}
+		pairs[value[:i]] = value[i+1:]
+	}
+	return pairs, nil
+}
+
+// s3Sink returns the s3 sink of the flags
+func s3Sink(c *cli.Context) (config.Sink, error) {
+	tags, err := keyValues(c.StringSlice("tag"))
+	if err != nil {
+		return config.Sink{}, err
+	}
+	metadata, err := keyValues(c.StringSlice("metadata"))
+	if err != nil {
+		return config.Sink{}, err
+	}
+
+	sink := config.Sink{
+		Type:         config.SinkS3,
+		S3Path:       c.String("s3Path"),
+		Region:       c.String("region"),
+		Encryption:   c.String("sse"),
+		KMSKeyID:     c.String("sse-kms-key-id"),
+		StorageClass: c.String("storage-class"),
+		ACL:          c.String("acl"),
+		Tags:         tags,
+		Metadata:     metadata,
+		EnvelopeKeys: c.StringSlice("envelope-key"),
+		Idempotent:   c.Bool("idempotent"),
+		Manifest:     c.Bool("manifest"),
+		Catalog:      c.String("catalog"),
+		Table:        c.String("table"),
+		EventTime: config.EventTime{
+			Field:    c.String("event-time-field"),
+			Format:   c.String("event-time-format"),
+			Fallback: c.String("event-time-fallback"),
+		},
+	}
+	if period := c.Duration("retention-period"); period > 0 {
+		sink.Retention = config.Retention{
+			Period:       period,
+			StorageClass: c.String("retention-storage-class"),
+			Interval:     c.Duration("retention-interval"),
+		}
+	}
+	return sink, sink.Validate()
+}
+
+func optionParser(c *cli.Context, mode string) (*config.Pipeline, error) {
 	bufferPath := c.String("buffer")
 	socketPath := c.String("unix")
 	if socketPath == "" {
 		return nil, ErrOptionRequired
 	}
-	s3Path := c.String("s3Path")
-	if s3Path == "" {
+	if c.String("s3Path") == "" || c.String("region") == "" {
 		return nil, ErrOptionRequired
 	}
-	region := c.String("region")
-	if region == "" {
-		return nil, ErrOptionRequired
+	sink, err := s3Sink(c)
+	if err != nil {
+		return nil, err
+	}
+	conf := &config.Pipeline{
+		Name: "s4",
+		Input: config.Input{
+			Mode:       mode,
+			Socket:     socketPath,
+			Reconnect:  c.Bool("reconnect"),
+			Retries:    c.Int("reconnect-retries"),
+			Backoff:    c.Duration("reconnect-backoff"),
+			MaxBackoff: c.Duration("reconnect-max-backoff"),
+		},
+		River: config.River{
+			Type:         c.String("type"),
+			Buffer:       bufferPath,
+			Flush:        c.Duration("flush"),
+			MaxBuffer:    c.Int64("max-buffer"),
+			Overflow:     c.String("overflow"),
+			SegmentSize:  c.Int64("segment-size"),
+			SegmentAge:   c.Duration("segment-age"),
+			Durability:   c.String("durability"),
+			SyncInterval: c.Duration("sync-interval"),
+			Keys:         c.StringSlice("buffer-key"),
+		},
+		Processors: processOptions(c),
+		Upload: config.Upload{
+			Concurrency: c.Int("upload-concurrency"),
+			Queue:       c.Int("upload-queue"),
+			Ordered:     c.Bool("upload-ordered"),
+			Timeout:     c.Duration("upload-timeout"),
+		},
+		Sinks: []config.Sink{sink},
 	}
-	flush := c.Duration("flush")
-	bucket, key := path.Split(s3Path)
-	bucket = strings.TrimRight(bucket, "/")
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
 
-	s3lake := lake.NewS3Supplyer(region, bucket, key)
+// waitSignal waits for a signal or the end of the pipeline
+func waitSignal(done <-chan struct{}) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case s := <-sig:
+		logger.Printf("Receive the signal %s", s)
+	case <-done:
+	}
+	signal.Stop(sig)
+}
 
-	config := &river.Config{
-		BufferPath:        bufferPath,
-		SocketPath:        socketPath,
-		FlushIntervalTime: flush,
-		Supplyer:          s3lake,
+func serve(c *cli.Context, mode string) error {
+	conf, err := optionParser(c, mode)
+	if err != nil {
+		return err
 	}
-	return config, nil
+
+	p, err := pipeline.New(*conf)
+	if err != nil {
+		return err
+	}
+	if err := p.Start(context.Background()); err != nil {
+		_ = p.Stop(context.Background())
+		return err
+	}
+	waitSignal(p.Done())
+	if err := p.Stop(context.Background()); err != nil {
+		return err
+	}
+	return p.Err()
 }
 
-func connect(r river.River) {
-	r.Connect()
-	r.Consume().Subscribe(func(data []byte) {
-		if err := r.Push(data); err != nil {
-			log.Print(err)
-		}
-	})
+func s4Client(c *cli.Context) error {
+	return serve(c, config.ModeClient)
 }
 
-func listen(r river.River) {
-	r.Listen()
-	r.Consume().Subscribe(func(data []byte) {
-		if err := r.Push(data); err != nil {
-			log.Print(err)
-		}
-	})
+func s4Server(c *cli.Context) error {
+	return serve(c, config.ModeServer)
 }
 
-func s4Client(c *cli.Context) error {
-	config, err := optionParser(c)
+func s4Run(c *cli.Context) error {
+	conf, err := config.Load(c.String("config"))
 	if err != nil {
 		return err
 	}
-	rivername := c.String("type")
 
-	switch rivername {
-	case "line":
-		liner := river.NewLineRiver(config)
-		connect(liner)
-	case "json":
-		jsonr := river.NewJSONRiver(config)
-		connect(jsonr)
+	if conf.Metrics != "" {
+		go func() {
+			logger.Errorf("Serve the metrics: %v", http.ListenAndServe(conf.Metrics, nil))
+		}()
 	}
-	return nil
-}
 
-func s4Server(c *cli.Context) error {
-	config, err := optionParser(c)
+	group, err := pipeline.NewGroup(conf)
 	if err != nil {
 		return err
 	}
-	rivername := c.String("type")
+	if err := group.Start(context.Background()); err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for s := range sig {
+		if s != syscall.SIGHUP {
+			logger.Printf("Receive the signal %s", s)
+			break
+		}
 
-	switch rivername {
-	case "line":
-		liner := river.NewLineRiver(config)
-		listen(liner)
-	case "json":
-		jsonr := river.NewJSONRiver(config)
-		listen(jsonr)
+		reloaded, err := config.Load(c.String("config"))
+		if err == nil {
+			err = group.Reload(reloaded)
+		}
+		if err != nil {
+			logger.Errorf("Reject the configuration: %v", err)
+		}
 	}
-	return nil
+	signal.Stop(sig)
+	return group.Stop(context.Background())
 }
 
 func mockingTest(c *cli.Context) error {
@@ -149,8 +522,13 @@ func mockingTest(c *cli.Context) error {
 		Supplyer:          lake.NewConsoleSupplyer(),
 	}
 
-	river := river.NewJSONRiver(config)
-	river.Connect()
+	river, err := river.OpenJSONRiver(config)
+	if err != nil {
+		return err
+	}
+	if _, err := river.Connect(); err != nil {
+		return err
+	}
 	deadline := time.After(time.Second * 10)
 	consumer := river.Consume()
 	consumer.Subscribe(func(data []byte) {
@@ -159,15 +537,57 @@ func mockingTest(c *cli.Context) error {
 			done <- struct{}{}
 			consumer.Cancel()
 		default:
-			log.Print(string(data))
+			logger.Print(string(data))
 		}
 	})
 	return nil
 }
 
+var logConfigFlag = []cli.Flag{
+	cli.StringFlag{
+		Name:   "log-level",
+		Value:  "info",
+		Usage:  "level of the logs, debug, info, warn or error",
+		EnvVar: "S4_LOG_LEVEL",
+	},
+	cli.StringFlag{
+		Name:   "log-format",
+		Value:  logger.FormatText,
+		Usage:  "format of the logs, text, json or logfmt",
+		EnvVar: "S4_LOG_FORMAT",
+	},
+}
+
+// configureLog sets the level and the format of the logs,
+// the json and logfmt logs carry their own time instead of the prefix of the standard logger
+func configureLog(c *cli.Context) error {
+	level, err := logger.ParseLevel(c.String("log-level"))
+	if err != nil {
+		return err
+	}
+	if err := logger.SetFormat(c.String("log-format")); err != nil {
+		return err
+	}
+	logger.SetLevel(level)
+	if c.String("log-format") != logger.FormatText {
+		log.SetFlags(0)
+	}
+	return nil
+}
+
+func flags(groups ...[]cli.Flag) []cli.Flag {
+	var merged []cli.Flag
+	for _, group := range groups {
+		merged = append(merged, group...)
+	}
+	return merged
+}
+
 // NewApp new CLI app
 func NewApp() *cli.App {
 	app := cli.NewApp()
+	app.Flags = logConfigFlag
+	app.Before = configureLog
 
 	app.Commands = []cli.Command{
 		{
@@ -178,18 +598,38 @@ func NewApp() *cli.App {
 		},
 		{
 			Name:    "client",
-			Flags:   append(s3ConfigFlag, bufferConfigFlag...),
+			Flags:   flags(s3ConfigFlag, bufferConfigFlag, processConfigFlag, uploadConfigFlag, reconnectConfigFlag),
 			Aliases: []string{"c"},
 			Usage:   "connect unix socket and stream to s3",
 			Action:  s4Client,
 		},
 		{
 			Name:    "server",
-			Flags:   append(s3ConfigFlag, bufferConfigFlag...),
+			Flags:   flags(s3ConfigFlag, bufferConfigFlag, processConfigFlag, uploadConfigFlag, retentionScheduleFlag),
 			Aliases: []string{"s"},
 			Usage:   "listen connection and stream to s3",
 			Action:  s4Server,
 		},
+		{
+			Name: "run",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "config",
+					Value:  "./s4.yaml",
+					Usage:  "path of the configuration file",
+					EnvVar: "S4_CONFIG",
+				},
+			},
+			Aliases: []string{"r"},
+			Usage:   "run the pipelines of the configuration file",
+			Action:  s4Run,
+		},
+		bufferCommand(),
+		replayCommand(),
+		decryptCommand(),
+		catalogCommand(),
+		compactCommand(),
+		retentionCommand(),
 	}
 
 	app.Name = "s4"
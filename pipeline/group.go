@@ -0,0 +1,236 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/logger"
+)
+
+// Group runs the pipelines of a configuration in one process
+type Group struct {
+	conf      *config.Config
+	pipelines map[string]*Pipeline
+	mutex     *sync.Mutex
+}
+
+// NewGroup builds every pipeline of the configuration
+func NewGroup(conf *config.Config) (*Group, error) {
+	g := &Group{
+		conf:      conf,
+		pipelines: make(map[string]*Pipeline),
+		mutex:     &sync.Mutex{},
+	}
+	for _, pc := range conf.Pipelines {
+		p, err := New(pc)
+		if err != nil {
+			g.close()
+			return nil, err
+		}
+		g.pipelines[p.Name] = p
+	}
+	return g, nil
+}
+
+func (g *Group) close() {
+	for _, p := range g.pipelines {
+		_ = p.river.Close()
+	}
+}
+
+// Start starts every pipeline, the started ones are stopped when a pipeline fails to start
+func (g *Group) Start(ctx context.Context) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	started := make(map[string]*Pipeline)
+	for name, p := range g.pipelines {
+		if err := p.Start(ctx); err != nil {
+			_ = each(context.Background(), started, (*Pipeline).Stop)
+			return fmt.Errorf("pipeline %s: %v", name, err)
+		}
+		started[name] = p
+	}
+	return nil
+}
+
+// Stop stops every pipeline concurrently and waits until their buffers are flushed
+// or the context is done
+func (g *Group) Stop(ctx context.Context) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return each(ctx, g.pipelines, (*Pipeline).Stop)
+}
+
+// each runs the action on every pipeline concurrently, returns the first error
+func each(ctx context.Context, pipelines map[string]*Pipeline, action func(*Pipeline, context.Context) error) error {
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, len(pipelines))
+	for _, p := range pipelines {
+		wg.Add(1)
+		go func(p *Pipeline) {
+			defer wg.Done()
+			errs <- action(p, ctx)
+		}(p)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload applies a new configuration, starts the added pipelines,
+// drains and stops the removed ones and restarts the changed ones keeping their buffers.
+// an invalid configuration is rejected without disturbing the running pipelines,
+// a changed pipeline that fails to start keeps running with its previous configuration
+func (g *Group) Reload(conf *config.Config) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+	confs := make(map[string]config.Pipeline)
+	for _, pc := range conf.Pipelines {
+		if _, _, err := Processors(pc.Processors); err != nil {
+			return err
+		}
+		if _, err := crypt.ParseKeyring(pc.River.Keys); err != nil {
+			return err
+		}
+		for _, sink := range pc.Sinks {
+			if _, err := crypt.ParseKeyring(sink.EnvelopeKeys); err != nil {
+				return err
+			}
+		}
+		confs[pc.Name] = pc
+	}
+	olds := make(map[string]config.Pipeline)
+	for _, pc := range g.conf.Pipelines {
+		olds[pc.Name] = pc
+	}
+
+	diff := config.Compare(g.conf, conf)
+	logger.Printf("Reload the configuration, %s", diff)
+	if conf.Metrics != g.conf.Metrics {
+		logger.Warnf("the metrics address takes effect after restart")
+	}
+
+	removed := make(map[string]*Pipeline)
+	for _, name := range diff.Removed {
+		removed[name] = g.pipelines[name]
+		delete(g.pipelines, name)
+	}
+	_ = each(context.Background(), removed, (*Pipeline).Stop)
+
+	var failed []string
+	for name := range diff.Changed {
+		if err := g.replace(olds[name], confs[name]); err != nil {
+			logger.With("pipeline", name).Errorf("The pipeline is not reloaded: %v", err)
+			failed = append(failed, name)
+		}
+	}
+	for _, name := range diff.Added {
+		p, err := New(confs[name])
+		if err == nil {
+			if err = p.Start(context.Background()); err != nil {
+				_ = p.Stop(context.Background())
+			}
+		}
+		if err != nil {
+			logger.With("pipeline", name).Errorf("The pipeline is not started: %v", err)
+			failed = append(failed, name)
+			continue
+		}
+		g.pipelines[name] = p
+	}
+
+	// the configuration keeps what runs, the previous configuration of the pipelines that failed
+	applied := *conf
+	applied.Pipelines = nil
+	for _, pc := range conf.Pipelines {
+		if !contains(failed, pc.Name) {
+			applied.Pipelines = append(applied.Pipelines, pc)
+		} else if _, ok := g.pipelines[pc.Name]; ok {
+			applied.Pipelines = append(applied.Pipelines, olds[pc.Name])
+		}
+	}
+	g.conf = &applied
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("pipelines %s are not reloaded", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// replace restarts the pipeline with the new configuration,
+// the pipeline is restarted with the old configuration when the new one fails
+func (g *Group) replace(oldConf, newConf config.Pipeline) error {
+	ctx := context.Background()
+	old := g.pipelines[oldConf.Name]
+	delete(g.pipelines, oldConf.Name)
+
+	sameBuffer := oldConf.River.Type == newConf.River.Type && oldConf.River.Buffer == newConf.River.Buffer
+	var p *Pipeline
+	var err error
+	if sameBuffer {
+		// the buffer is locked by the running pipeline, it is suspended and resumed by the new one
+		_ = old.Suspend(ctx)
+		if p, err = New(newConf); err != nil {
+			return g.restore(oldConf, err)
+		}
+	} else {
+		// the new buffer is opened before the running pipeline is disturbed,
+		// the old buffer is drained to its sinks since nothing resumes it
+		if p, err = New(newConf); err != nil {
+			g.pipelines[oldConf.Name] = old
+			return err
+		}
+		_ = old.Stop(ctx)
+	}
+
+	if err := p.Start(ctx); err != nil {
+		if sameBuffer {
+			_ = p.Suspend(ctx)
+		} else {
+			_ = p.Stop(ctx)
+		}
+		return g.restore(oldConf, err)
+	}
+	g.pipelines[newConf.Name] = p
+	return nil
+}
+
+// restore starts the pipeline of the old configuration again, it returns the cause
+func (g *Group) restore(conf config.Pipeline, cause error) error {
+	p, err := New(conf)
+	if err == nil {
+		if err = p.Start(context.Background()); err != nil {
+			_ = p.Suspend(context.Background())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%v, the previous configuration fails too: %v", cause, err)
+	}
+	g.pipelines[conf.Name] = p
+	return cause
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/river"
+)
+
+func groupConfig(names ...string) *config.Config {
+	conf := &config.Config{}
+	for _, name := range names {
+		conf.Pipelines = append(conf.Pipelines, config.Pipeline{
+			Name:  name,
+			Input: config.Input{Socket: "./" + name + ".sock"},
+			River: config.River{Buffer: "./" + name + ".tmp", Flush: time.Second},
+			Sinks: []config.Sink{{Type: config.SinkConsole}},
+		})
+	}
+	return conf
+}
+
+func TestGroupReload(t *testing.T) {
+	conf := groupConfig("first", "second")
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	group, err := NewGroup(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := groupConfig("first")
+	invalid.Pipelines[0].Processors.Redact = []string{"unknown"}
+	if err := group.Reload(invalid); err == nil {
+		t.Fatal("invalid configuration must be rejected")
+	}
+	if len(group.pipelines) != 2 {
+		t.Fatal("rejected configuration must not disturb the pipelines")
+	}
+
+	reloaded := groupConfig("second", "third")
+	reloaded.Pipelines[0].River.Flush = time.Second * 2
+	if err := group.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := group.pipelines["first"]; ok || len(group.pipelines) != 2 {
+		t.Fatalf("unexpected pipelines: %v", group.pipelines)
+	}
+	if err := group.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupReloadFailure(t *testing.T) {
+	conf := groupConfig("kept")
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("./kept.tmp")
+	group, err := NewGroup(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := group.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer group.Stop(context.Background())
+
+	// a valid configuration that cannot start keeps the running pipeline
+	unstartable := groupConfig("kept")
+	unstartable.Pipelines[0].Input = config.Input{Mode: config.ModeClient, Socket: "./absent.sock"}
+	if err := group.Reload(unstartable); err == nil {
+		t.Fatal("unstartable pipeline must be reported")
+	}
+	p, ok := group.pipelines["kept"]
+	if !ok || p.conf.Input.Mode != config.ModeServer {
+		t.Fatalf("the pipeline must keep its configuration: %v", group.pipelines)
+	}
+	if group.conf.Pipelines[0].Input.Mode != config.ModeServer {
+		t.Fatal("the group must keep the previous configuration")
+	}
+
+	// a new buffer drains the old one
+	p.River().Flow([]byte("before the reload\n"))
+	moved := groupConfig("kept")
+	moved.Pipelines[0].River.Buffer = "./moved.tmp"
+	defer os.RemoveAll("./moved.tmp")
+	if err := group.Reload(moved); err != nil {
+		t.Fatal(err)
+	}
+	old, err := river.OpenLineRiver(&river.Config{BufferPath: "./kept.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	if stat, err := old.Stat(); err != nil || stat.Records != 0 {
+		t.Fatalf("the old buffer must be drained: %v %v", stat, err)
+	}
+}
@@ -0,0 +1,465 @@
+package pipeline
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/process"
+	"github.com/findcoo/s4/river"
+)
+
+var (
+	// Metrics shared counters of the pipelines, exposed by expvar
+	Metrics = expvar.NewMap("s4_pipeline")
+)
+
+// Pipeline an input flows through a river to the sinks
+type Pipeline struct {
+	Name      string
+	conf      config.Pipeline
+	log       *logger.Entry
+	config    *river.Config
+	river     river.River
+	stopInput func()
+	// stopConsume stops the consumer, done is closed after it
+	stopConsume chan struct{}
+	done        chan struct{}
+	once        *sync.Once
+	stopped     chan struct{}
+	// err the failure of the input that stopped the pipeline
+	err error
+	// ctx cancels the uploads when the stop outlasts its context
+	ctx           context.Context
+	cancelUploads context.CancelFunc
+	uploads       *uploader
+	sinks         lake.MultiSink
+	// retentions run periodically while the pipeline runs
+	retentions     []*lake.Retention
+	intervals      []time.Duration
+	stopRetentions []func()
+}
+
+// New builds the river, the processors and the sinks of the pipeline
+func New(conf config.Pipeline) (*Pipeline, error) {
+	chain, newConnChain, err := Processors(conf.Processors)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Processors.Stamp != "" {
+		newConnChain = stampChain(conf.Name, conf.Processors.Stamp, newConnChain)
+	}
+	keyring, err := crypt.ParseKeyring(conf.River.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	log := logger.With("pipeline", conf.Name)
+	var sinks lake.MultiSink
+	for _, sink := range conf.Sinks {
+		s, err := NewSink(sink)
+		if err != nil {
+			return nil, err
+		}
+		if s3supplyer, ok := s.(*lake.S3Supplyer); ok {
+			s3supplyer.Options.Timeout = conf.Upload.Timeout
+			s3supplyer.Logger = log.With("sink", sink.S3Path)
+		}
+		sinks = append(sinks, s)
+	}
+
+	riverConfig := &river.Config{
+		BufferPath:        conf.River.Buffer,
+		SocketPath:        conf.Input.Socket,
+		FlushIntervalTime: conf.River.Flush,
+		MaxBufferSize:     conf.River.MaxBuffer,
+		SegmentSize:       conf.River.SegmentSize,
+		SegmentAge:        conf.River.SegmentAge,
+		Durability:        conf.River.Durability,
+		SyncInterval:      conf.River.SyncInterval,
+		OverflowPolicy:    conf.River.Overflow,
+		Keyring:           keyring,
+		Processors:        chain,
+		ConnProcessors:    newConnChain,
+		Logger:            log,
+		Supplyer:          sinks,
+	}
+	if len(sinks) == 1 {
+		riverConfig.Supplyer = &lake.SinkSupplyer{Sink: sinks[0]}
+	}
+
+	r, err := river.NewRiver(conf.River.Type, riverConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{
+		Name:    conf.Name,
+		conf:    conf,
+		log:     log,
+		config:  riverConfig,
+		river:   r,
+		sinks:   sinks,
+		once:    &sync.Once{},
+		stopped: make(chan struct{}),
+	}
+	p.ctx, p.cancelUploads = context.WithCancel(context.Background())
+	for _, sink := range conf.Sinks {
+		if sink.Retention.Interval <= 0 {
+			continue
+		}
+		retention, err := NewRetention(sink)
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		if retention != nil {
+			p.retentions = append(p.retentions, retention)
+			p.intervals = append(p.intervals, sink.Retention.Interval)
+		}
+	}
+	return p, nil
+}
+
+// Processors builds the processors of the river and the processors of each connection
+func Processors(conf config.Processors) (process.Chain, func(conn uint64) process.Chain, error) {
+	var chain process.Chain
+
+	var rules []*process.Rule
+	for _, def := range conf.Redact {
+		rule, err := process.ParseRule(def)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", def, err)
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) > 0 {
+		chain = append(chain, process.NewLineRedactor(rules...))
+	}
+
+	var fieldRules []*process.FieldRule
+	for _, def := range conf.RedactFields {
+		rule, err := process.ParseFieldRule(def)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", def, err)
+		}
+		fieldRules = append(fieldRules, rule)
+	}
+	if len(fieldRules) > 0 {
+		chain = append(chain, process.NewJSONRedactor(fieldRules...))
+	}
+
+	if conf.Sample > 0 && conf.Sample < 1 {
+		chain = append(chain, process.NewSampler(conf.Sample, conf.SampleKey))
+	}
+
+	rate := conf.Rate
+	if rate.Records > 0 || rate.Bytes > 0 {
+		limiter, err := process.NewRateLimiter("river", rate.Records, rate.Bytes, rate.Policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, limiter)
+	}
+
+	connRate := conf.ConnRate
+	if connRate.Records <= 0 && connRate.Bytes <= 0 {
+		return chain, nil, nil
+	}
+	if _, err := process.NewRateLimiter("conn", connRate.Records, connRate.Bytes, connRate.Policy); err != nil {
+		return nil, nil, err
+	}
+	newConnChain := func(conn uint64) process.Chain {
+		limiter, _ := process.NewRateLimiter("conn", connRate.Records, connRate.Bytes, connRate.Policy)
+		return process.Chain{limiter}
+	}
+	return chain, newConnChain, nil
+}
+
+// stampChain appends a Stamper to the chain of each connection, the stamps carry the id of the connection
+// that the logs of the input carry
+func stampChain(input, format string, newChain func(conn uint64) process.Chain) func(conn uint64) process.Chain {
+	return func(conn uint64) process.Chain {
+		var chain process.Chain
+		if newChain != nil {
+			chain = newChain(conn)
+		}
+		stamper, err := process.NewStamper(format, input, conn)
+		if err != nil {
+			logger.With("pipeline", input).Errorf("Stamp the records: %v", err)
+			return chain
+		}
+		return append(chain, stamper)
+	}
+}
+
+// NewSink returns the Sink of the configuration
+func NewSink(conf config.Sink) (lake.Sink, error) {
+	envelope, err := crypt.ParseKeyring(conf.EnvelopeKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	switch conf.Type {
+	case config.SinkS3:
+		bucket, key := path.Split(conf.S3Path)
+		bucket = strings.TrimRight(bucket, "/")
+		s3supplyer, err := lake.OpenS3Supplyer(conf.Region, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		s3supplyer.Options = lake.S3Options{
+			Encryption:   conf.Encryption,
+			KMSKeyID:     conf.KMSKeyID,
+			StorageClass: conf.StorageClass,
+			ACL:          conf.ACL,
+			Tags:         conf.Tags,
+			Metadata:     conf.Metadata,
+			Envelope:     envelope,
+			Idempotent:   conf.Idempotent,
+			Manifest:     conf.Manifest,
+		}
+		if conf.Catalog != "" {
+			catalog, err := lake.OpenCatalog(conf.Catalog)
+			if err != nil {
+				return nil, err
+			}
+			s3supplyer.Options.Catalog = catalog
+			s3supplyer.Options.Table = conf.Table
+			if conf.Table == "" {
+				s3supplyer.Options.Table = lake.TableName(key)
+			}
+		}
+		if conf.EventTime.Field != "" {
+			s3supplyer.Options.EventTime = &lake.EventTime{
+				Field:    conf.EventTime.Field,
+				Format:   conf.EventTime.Format,
+				Fallback: conf.EventTime.Fallback,
+			}
+		}
+		return s3supplyer, nil
+	}
+	if envelope != nil {
+		return lake.AsSink(&lake.EnvelopeSupplyer{Keyring: envelope, Supplyer: lake.NewConsoleSupplyer()}), nil
+	}
+	return lake.NewConsoleSupplyer(), nil
+}
+
+// NewRetention returns the Retention of the s3 sink, nil without a period
+func NewRetention(conf config.Sink) (*lake.Retention, error) {
+	if conf.Type != config.SinkS3 || conf.Retention.Period <= 0 {
+		return nil, nil
+	}
+	bucket, key := path.Split(conf.S3Path)
+	retention, err := lake.NewRetention(conf.Region, strings.TrimRight(bucket, "/"), key, conf.Retention.Period)
+	if err != nil {
+		return nil, err
+	}
+	retention.StorageClass = conf.Retention.StorageClass
+	retention.DryRun = conf.Retention.DryRun
+	retention.Options = lake.S3Options{
+		Encryption: conf.Encryption,
+		KMSKeyID:   conf.KMSKeyID,
+	}
+	return retention, nil
+}
+
+// drain stops the input and the consumer and flushes the buffer
+func (p *Pipeline) drain() {
+	p.stopInput()
+	for _, stopRetention := range p.stopRetentions {
+		stopRetention()
+	}
+	close(p.stopConsume)
+	<-p.done
+	if p.uploads != nil {
+		p.uploads.close()
+	}
+	// the memory river does not outlive the pipeline, it is flushed even if the buffer is kept
+	if p.config.KeepBuffer && p.conf.River.Type != river.TypeMemory {
+		return
+	}
+	if err := river.FlushContext(p.ctx, p.river); err != nil {
+		p.log.Errorf("Flush the buffer: %v", err)
+	}
+}
+
+// River returns the river of the pipeline
+func (p *Pipeline) River() river.River {
+	return p.river
+}
+
+// Start starts the input and the consumer of the river, the pipeline stops when the context is done
+func (p *Pipeline) Start(ctx context.Context) error {
+	p.log.Infof("Start the pipeline")
+	switch p.conf.Input.Mode {
+	case config.ModeClient:
+		if p.conf.Input.Reconnect {
+			// the reconnection starts after the consumer since it stops the pipeline when the retries run out
+			break
+		}
+		us, err := p.river.Connect()
+		if err != nil {
+			return err
+		}
+		p.stopInput = us.Cancel
+	case config.ModeServer:
+		stop, err := p.river.Listen()
+		if err != nil {
+			return err
+		}
+		p.stopInput = stop
+	}
+
+	p.stopRetentions = nil
+	for i, retention := range p.retentions {
+		p.stopRetentions = append(p.stopRetentions, retention.Schedule(p.intervals[i]))
+	}
+
+	p.uploads = nil
+	if upload := p.conf.Upload; upload.Concurrency > 0 {
+		p.uploads = newUploader(upload.Concurrency, upload.Queue, upload.Ordered)
+	}
+
+	p.done = make(chan struct{})
+	p.stopConsume = make(chan struct{})
+	go p.consume()
+	if p.conf.Input.Mode == config.ModeClient && p.conf.Input.Reconnect {
+		p.reconnect()
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = p.Stop(context.Background())
+			case <-p.stopped:
+			}
+		}()
+	}
+	return nil
+}
+
+// consume takes a batch of the river at each flush interval and pushes it
+func (p *Pipeline) consume() {
+	defer close(p.done)
+	p.log.Infof("Consume the flow")
+	ticker := time.NewTicker(p.config.FlushIntervalTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopConsume:
+			return
+		case <-ticker.C:
+		}
+
+		batch, err := p.river.Ready()
+		if err != nil {
+			p.log.Errorf("Read the buffer: %v", err)
+			continue
+		}
+		if batch == nil {
+			continue
+		}
+		p.config.Processors.Report()
+		if p.uploads == nil {
+			p.push(batch)
+			continue
+		}
+		// the batches are ordered by the partition of their objects, the hint of the batch
+		// or the day of the flush like the s3 sinks
+		p.uploads.submit(batch.PartitionAt(time.Now()), func() {
+			p.push(batch)
+		})
+	}
+}
+
+// push commits the batch to the river, its records are deleted after the push succeeds
+// and kept when the uploads are canceled by the stop
+func (p *Pipeline) push(batch *lake.Batch) {
+	if err := p.river.Commit(p.ctx, batch); err != nil {
+		Metrics.Add(p.Name+".errors", 1)
+		p.log.Errorf("Push the batch: %v", err)
+		return
+	}
+	Metrics.Add(p.Name+".batches", 1)
+	Metrics.Add(p.Name+".bytes", int64(len(batch.Data)))
+}
+
+// Stop stops the input, flushes the buffer and closes the river,
+// it returns the error of the context done before the end, then the uploads are canceled
+// keeping their records in the buffer and the stop goes on in the background
+func (p *Pipeline) Stop(ctx context.Context) error {
+	return p.stop(ctx, false)
+}
+
+// Suspend stops the input and closes the river keeping the buffer,
+// a pipeline on the same buffer path resumes it
+func (p *Pipeline) Suspend(ctx context.Context) error {
+	return p.stop(ctx, true)
+}
+
+// Done returns a channel closed when the pipeline is stopped
+func (p *Pipeline) Done() <-chan struct{} {
+	return p.stopped
+}
+
+// Err returns the failure of the input that stopped the pipeline
+func (p *Pipeline) Err() error {
+	select {
+	case <-p.stopped:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// stop runs once, the later calls wait for the first one
+func (p *Pipeline) stop(ctx context.Context, suspend bool) error {
+	p.once.Do(func() {
+		if suspend {
+			p.log.Infof("Suspend the pipeline")
+			p.config.KeepBuffer = true
+		} else {
+			p.log.Infof("Stop the pipeline")
+		}
+		go func() {
+			p.shutdown()
+			close(p.stopped)
+		}()
+	})
+
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		p.cancelUploads()
+		return ctx.Err()
+	}
+}
+
+func (p *Pipeline) shutdown() {
+	// a pipeline that is never started only closes the river and the sinks
+	if p.stopConsume != nil {
+		p.drain()
+	}
+	if err := p.river.Close(); err != nil {
+		p.log.Errorf("Close the river: %v", err)
+	}
+	if err := p.sinks.Flush(p.ctx); err != nil {
+		p.log.Errorf("Flush the sinks: %v", err)
+	}
+	if err := p.sinks.Close(); err != nil {
+		p.log.Errorf("Close the sinks: %v", err)
+	}
+	p.cancelUploads()
+}
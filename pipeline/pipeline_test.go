@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/input"
+	"github.com/findcoo/s4/process"
+	"github.com/findcoo/s4/test"
+)
+
+var testPipeline = config.Pipeline{
+	Name: "test",
+	Input: config.Input{
+		Mode:   config.ModeServer,
+		Socket: "./pipeline.sock",
+	},
+	River: config.River{
+		Type:   "line",
+		Buffer: "./pipeline.tmp",
+		Flush:  time.Second,
+	},
+	Processors: config.Processors{
+		Redact: []string{"email"},
+		ConnRate: config.Rate{
+			Records: 100,
+		},
+	},
+	Sinks: []config.Sink{{Type: config.SinkConsole}},
+}
+
+func TestProcessors(t *testing.T) {
+	if err := testPipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, newConnChain, err := Processors(testPipeline.Processors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 1 || newConnChain == nil || len(newConnChain(1)) != 1 {
+		t.Fatal("unexpected processors")
+	}
+
+	if _, _, err := Processors(config.Processors{Redact: []string{"unknown"}}); err == nil {
+		t.Fatal("unknown rule must be rejected")
+	}
+}
+
+func TestStampChain(t *testing.T) {
+	newConnChain := stampChain("app", process.StampPrefix, nil)
+	first, second := newConnChain(7), newConnChain(9)
+
+	_ = first.Process([]byte("hello\n"))
+	if stamped := string(first.Process([]byte("hello\n"))); !strings.Contains(stamped, " app 7 2 hello") {
+		t.Fatalf("unexpected stamp of the first connection: %s", stamped)
+	}
+	if stamped := string(second.Process([]byte("hello\n"))); !strings.Contains(stamped, " app 9 1 hello") {
+		t.Fatalf("unexpected stamp of the second connection: %s", stamped)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	if err := testPipeline.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(testPipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	test.LockUntilReady(testPipeline.Input.Socket)
+	test.UnixTestClient(testPipeline.Input.Socket)
+	time.Sleep(time.Second * 2)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineContext(t *testing.T) {
+	conf := testPipeline
+	conf.Input.Socket = "./context.sock"
+	conf.River.Buffer = "./context.tmp"
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(conf.River.Buffer)
+
+	p, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := p.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelTimeout()
+	if err := p.Stop(timeout); err != nil {
+		t.Fatalf("pipeline is not stopped by the context: %v", err)
+	}
+}
+
+func TestPipelineStartError(t *testing.T) {
+	conf := testPipeline
+	conf.Input = config.Input{Mode: config.ModeClient, Socket: "./absent.sock"}
+	conf.River.Buffer = "./absent.tmp"
+	defer os.RemoveAll(conf.River.Buffer)
+
+	p, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop(context.Background())
+	if err := p.Start(context.Background()); err == nil {
+		t.Fatal("absent socket must fail the start")
+	}
+}
+
+func TestPipelineReconnectRetries(t *testing.T) {
+	conf := testPipeline
+	conf.Input = config.Input{
+		Mode:      config.ModeClient,
+		Socket:    "./absent.sock",
+		Reconnect: true,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+	}
+	conf.River.Buffer = "./reconnect.tmp"
+	defer os.RemoveAll(conf.River.Buffer)
+
+	p, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipeline must stop when the retries run out")
+	}
+	if p.Err() != input.ErrRetriesExceeded {
+		t.Fatalf("unexpected error: %v", p.Err())
+	}
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/findcoo/s4/input"
+)
+
+// reconnect connects the river to the waterhead and connects again whenever the connection ends,
+// the pipeline stops when the retries run out
+func (p *Pipeline) reconnect() {
+	conf := p.conf.Input
+	backoff := input.Backoff{
+		Initial: conf.Backoff,
+		Max:     conf.MaxBackoff,
+		Retries: conf.Retries,
+	}
+	log := p.log.With("input", conf.Socket)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	p.stopInput = func() {
+		close(stop)
+		<-done
+	}
+	go func() {
+		defer close(done)
+		if err := input.Reconnect(log, backoff, p.river.Connect, stop); err != nil {
+			log.Errorf("Stop the pipeline: %v", err)
+			p.err = err
+			go func() {
+				_ = p.Stop(context.Background())
+			}()
+		}
+	}()
+}
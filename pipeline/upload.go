@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// uploader pushes the batches on a pool of workers so that a slow sink does not block the consumer
+type uploader struct {
+	queues  []chan func()
+	ordered bool
+	wg      *sync.WaitGroup
+}
+
+// newUploader starts the workers, the ordered uploader gives each worker its own queue
+// so that the batches of a partition are pushed one by one in order
+func newUploader(concurrency, depth int, ordered bool) *uploader {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if depth < 0 {
+		depth = 0
+	}
+
+	queues := 1
+	if ordered {
+		queues = concurrency
+	}
+	u := &uploader{
+		queues:  make([]chan func(), queues),
+		ordered: ordered,
+		wg:      &sync.WaitGroup{},
+	}
+	for i := range u.queues {
+		u.queues[i] = make(chan func(), depth)
+	}
+	for i := 0; i < concurrency; i++ {
+		u.wg.Add(1)
+		go u.work(u.queues[i%queues])
+	}
+	return u
+}
+
+func (u *uploader) work(queue chan func()) {
+	defer u.wg.Done()
+	for upload := range queue {
+		upload()
+	}
+}
+
+// submit queues the upload of the partition, it blocks while the queue is full
+func (u *uploader) submit(partition string, upload func()) {
+	queue := u.queues[0]
+	if u.ordered {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(partition))
+		queue = u.queues[h.Sum32()%uint32(len(u.queues))]
+	}
+	queue <- upload
+}
+
+// close waits for the queued uploads
+func (u *uploader) close() {
+	for _, queue := range u.queues {
+		close(queue)
+	}
+	u.wg.Wait()
+}
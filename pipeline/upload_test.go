@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploaderOrder(t *testing.T) {
+	u := newUploader(4, 2, true)
+	mutex := &sync.Mutex{}
+	pushed := make(map[string][]int)
+	for i := 0; i < 20; i++ {
+		partition, i := []string{"2017-08-03", "2017-08-04"}[i%2], i
+		u.submit(partition, func() {
+			time.Sleep(time.Millisecond * time.Duration(20-i))
+			mutex.Lock()
+			pushed[partition] = append(pushed[partition], i)
+			mutex.Unlock()
+		})
+	}
+	u.close()
+
+	for partition, batches := range pushed {
+		if len(batches) != 10 {
+			t.Fatalf("%s: unexpected batches %v", partition, batches)
+		}
+		for j := 1; j < len(batches); j++ {
+			if batches[j] < batches[j-1] {
+				t.Fatalf("%s: batches out of order %v", partition, batches)
+			}
+		}
+	}
+}
+
+func TestUploaderConcurrency(t *testing.T) {
+	u := newUploader(3, 0, false)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		u.submit("", func() {
+			time.Sleep(time.Millisecond * 100)
+		})
+	}
+	u.close()
+	if elapsed := time.Since(start); elapsed > time.Millisecond*250 {
+		t.Fatalf("uploads are not concurrent: %s", elapsed)
+	}
+}
@@ -0,0 +1,144 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// policies of the RateLimiter when the rate is exceeded
+const (
+	PolicyBlock = "block"
+	PolicyDrop  = "drop"
+)
+
+var (
+	// ErrUnknownPolicy policy is neither block nor drop
+	ErrUnknownPolicy = errors.New("unknown rate limit policy")
+)
+
+// bucket token bucket that refills rate tokens per second up to a second of burst
+type bucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate float64) *bucket {
+	return &bucket{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// allow takes n tokens if available, a full bucket always admits one oversized take
+func (b *bucket) allow(n float64, now time.Time) bool {
+	b.refill(now)
+	if b.tokens < n && b.tokens < b.rate {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// reserve takes n tokens on credit and returns how long the caller has to wait
+func (b *bucket) reserve(n float64, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter limits the records and bytes per second,
+// blocks the sender or drops the records when the rate is exceeded
+type RateLimiter struct {
+	name    string
+	policy  string
+	records *bucket
+	bytes   *bucket
+	mutex   *sync.Mutex
+	dropped uint64
+}
+
+// NewRateLimiter returns a RateLimiter, zero rate means unlimited
+func NewRateLimiter(name string, records, bytes float64, policy string) (*RateLimiter, error) {
+	if policy != PolicyBlock && policy != PolicyDrop {
+		return nil, ErrUnknownPolicy
+	}
+
+	rl := &RateLimiter{
+		name:   name,
+		policy: policy,
+		mutex:  &sync.Mutex{},
+	}
+	if records > 0 {
+		rl.records = newBucket(records)
+	}
+	if bytes > 0 {
+		rl.bytes = newBucket(bytes)
+	}
+	return rl, nil
+}
+
+// Process admits the record, waits or drops when the rate is exceeded
+func (rl *RateLimiter) Process(data []byte) []byte {
+	rl.mutex.Lock()
+	now := time.Now()
+
+	if rl.policy == PolicyBlock {
+		var wait time.Duration
+		if rl.records != nil {
+			wait = rl.records.reserve(1, now)
+		}
+		if rl.bytes != nil {
+			if w := rl.bytes.reserve(float64(len(data)), now); w > wait {
+				wait = w
+			}
+		}
+		rl.mutex.Unlock()
+		time.Sleep(wait)
+		return data
+	}
+
+	allowed := rl.records == nil || rl.records.allow(1, now)
+	if allowed && rl.bytes != nil && !rl.bytes.allow(float64(len(data)), now) {
+		allowed = false
+		if rl.records != nil {
+			rl.records.tokens++
+		}
+	}
+	if !allowed {
+		rl.dropped++
+	}
+	rl.mutex.Unlock()
+
+	if !allowed {
+		Metrics.Add("limit."+rl.name+".dropped", 1)
+		return nil
+	}
+	return data
+}
+
+// Dropped returns the number of dropped records
+func (rl *RateLimiter) Dropped() uint64 {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return rl.dropped
+}
+
+// Report formats the number of dropped records
+func (rl *RateLimiter) Report() string {
+	return fmt.Sprintf("rate limit %s: dropped=%d", rl.name, rl.Dropped())
+}
@@ -0,0 +1,47 @@
+package process
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterDrop(t *testing.T) {
+	limiter, err := NewRateLimiter("test", 10, 0, PolicyDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var passed int
+	for i := 0; i < 20; i++ {
+		if limiter.Process([]byte("record\n")) != nil {
+			passed++
+		}
+	}
+	if passed != 10 || limiter.Dropped() != 10 {
+		t.Fatalf("passed %d, dropped %d", passed, limiter.Dropped())
+	}
+	t.Log(limiter.Report())
+}
+
+func TestRateLimiterBlock(t *testing.T) {
+	limiter, err := NewRateLimiter("test", 0, 100, PolicyBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if limiter.Process(make([]byte, 50)) == nil {
+			t.Fatal("blocking limiter must not drop")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*400 {
+		t.Fatalf("limiter did not block, elapsed %s", elapsed)
+	}
+}
+
+func TestRateLimiterPolicy(t *testing.T) {
+	if _, err := NewRateLimiter("test", 1, 1, "queue"); err != ErrUnknownPolicy {
+		t.Fatalf("expected ErrUnknownPolicy, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+
+	"github.com/findcoo/s4/logger"
+)
+
+var (
+	// Metrics shared counters of the processors, exposed by expvar
+	Metrics = expvar.NewMap("s4_process")
+)
+
+// Processor transforms a record before it flows into the buffer,
+// a nil result means the record is dropped
+type Processor interface {
+	Process(data []byte) []byte
+}
+
+// Reporter reports the statistics of a processor
+type Reporter interface {
+	Report() string
+}
+
+// Chain runs processors in order
+type Chain []Processor
+
+// Process passes the record through every processor of the chain
+func (c Chain) Process(data []byte) []byte {
+	for _, p := range c {
+		if data == nil {
+			return nil
+		}
+		data = p.Process(data)
+	}
+	return data
+}
+
+// Report logs the statistics of the processors that are Reporter
+func (c Chain) Report() {
+	for _, p := range c {
+		if r, ok := p.(Reporter); ok {
+			logger.Infof("%s", r.Report())
+		}
+	}
+}
+
+// decode parses a json object keeping the numbers as they are
+func decode(data []byte) (map[string]interface{}, bool) {
+	var record map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, false
+	}
+	return record, true
+}
+
+// parent returns the object holding the last field of the path
+func parent(record map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	for _, name := range path[:len(path)-1] {
+		child, ok := record[name].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		record = child
+	}
+	_, ok := record[path[len(path)-1]]
+	return record, ok
+}
@@ -0,0 +1,271 @@
+package process
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// field actions of the JSONRedactor
+const (
+	ActionHash   = "hash"
+	ActionRemove = "remove"
+)
+
+var (
+	// ErrUnknownRule unknown builtin rule or malformed rule definition
+	ErrUnknownRule = errors.New("unknown redaction rule")
+	// ErrUnknownAction field action is neither hash nor remove
+	ErrUnknownAction = errors.New("unknown redaction action")
+
+	builtinRules = map[string]string{
+		"email": `[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`,
+		"card":  `\b(?:\d[ \-]?){12,15}\d\b`,
+		"token": `(?i)\b(?:bearer|token|api[_\-]?key|secret)(["']?\s*[:=]\s*["']?|\s+)[a-zA-Z0-9._\-]{8,}`,
+	}
+	// builtinValidators reject the matches that are not secrets, timestamps and ids are not card numbers
+	builtinValidators = map[string]func([]byte) bool{
+		"card": luhn,
+	}
+)
+
+// luhn reports whether the digits of the match pass the Luhn checksum
+func luhn(match []byte) bool {
+	var sum, digits int
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+// counter counts the redactions per rule
+type counter struct {
+	mutex  *sync.Mutex
+	counts map[string]uint64
+}
+
+func newCounter() counter {
+	return counter{
+		mutex:  &sync.Mutex{},
+		counts: make(map[string]uint64),
+	}
+}
+
+func (c counter) add(rule string, n int) {
+	if n == 0 {
+		return
+	}
+	c.mutex.Lock()
+	c.counts[rule] += uint64(n)
+	c.mutex.Unlock()
+	Metrics.Add("redact."+rule, int64(n))
+}
+
+// Counts returns a copy of the redaction counts per rule
+func (c counter) Counts() map[string]uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]uint64, len(c.counts))
+	for rule, n := range c.counts {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// Report formats the redaction counts per rule
+func (c counter) Report() string {
+	counts := c.Counts()
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	report := make([]string, len(rules))
+	for i, rule := range rules {
+		report[i] = fmt.Sprintf("%s=%d", rule, counts[rule])
+	}
+	return "redactions: " + strings.Join(report, " ")
+}
+
+// Rule masks the matches of the pattern
+type Rule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Mask    []byte
+	// Valid masks only the matches it accepts, nil masks every match
+	Valid func([]byte) bool
+}
+
+// ParseRule parses a builtin rule name or a "name=regexp" definition
+func ParseRule(def string) (*Rule, error) {
+	name, expr := def, ""
+	if i := strings.Index(def, "="); i > 0 {
+		name, expr = def[:i], def[i+1:]
+	} else if builtin, ok := builtinRules[def]; ok {
+		expr = builtin
+	} else {
+		return nil, ErrUnknownRule
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{
+		Name:    name,
+		Pattern: pattern,
+		Mask:    []byte("[REDACTED:" + name + "]"),
+		Valid:   builtinValidators[def],
+	}
+	return rule, nil
+}
+
+// LineRedactor masks the records with regular expressions
+type LineRedactor struct {
+	rules []*Rule
+	counter
+}
+
+// NewLineRedactor returns a LineRedactor
+func NewLineRedactor(rules ...*Rule) *LineRedactor {
+	lr := &LineRedactor{
+		rules:   rules,
+		counter: newCounter(),
+	}
+	return lr
+}
+
+// Process masks every match of the rules
+func (lr *LineRedactor) Process(data []byte) []byte {
+	for _, rule := range lr.rules {
+		var n int
+		data = rule.Pattern.ReplaceAllFunc(data, func(match []byte) []byte {
+			if rule.Valid != nil && !rule.Valid(match) {
+				return match
+			}
+			n++
+			return rule.Mask
+		})
+		lr.add(rule.Name, n)
+	}
+	return data
+}
+
+// FieldRule hashes or removes the field of the path
+type FieldRule struct {
+	Path   []string
+	Action string
+}
+
+// ParseFieldRule parses a "path.to.field=hash|remove" definition
+func ParseFieldRule(def string) (*FieldRule, error) {
+	i := strings.LastIndex(def, "=")
+	if i <= 0 {
+		return nil, ErrUnknownRule
+	}
+	action := def[i+1:]
+	if action != ActionHash && action != ActionRemove {
+		return nil, ErrUnknownAction
+	}
+
+	rule := &FieldRule{
+		Path:   strings.Split(def[:i], "."),
+		Action: action,
+	}
+	return rule, nil
+}
+
+// Name returns the dotted path of the field
+func (fr *FieldRule) Name() string {
+	return strings.Join(fr.Path, ".")
+}
+
+// JSONRedactor hashes or removes the fields of json records
+type JSONRedactor struct {
+	rules []*FieldRule
+	counter
+}
+
+// NewJSONRedactor returns a JSONRedactor
+func NewJSONRedactor(rules ...*FieldRule) *JSONRedactor {
+	jr := &JSONRedactor{
+		rules:   rules,
+		counter: newCounter(),
+	}
+	return jr
+}
+
+// Process applies the field rules, a record that is not a json object passes through
+func (jr *JSONRedactor) Process(data []byte) []byte {
+	record, ok := decode(data)
+	if !ok {
+		return data
+	}
+
+	var redacted bool
+	for _, rule := range jr.rules {
+		if apply(record, rule) {
+			jr.add(rule.Name(), 1)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return data
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return data
+	}
+	if bytes.HasSuffix(data, []byte("\n")) {
+		encoded = append(encoded, '\n')
+	}
+	return encoded
+}
+
+func apply(record map[string]interface{}, rule *FieldRule) bool {
+	object, ok := parent(record, rule.Path)
+	if !ok {
+		return false
+	}
+
+	name := rule.Path[len(rule.Path)-1]
+	switch rule.Action {
+	case ActionRemove:
+		delete(object, name)
+	case ActionHash:
+		object[name] = hash(object[name])
+	}
+	return true
+}
+
+func hash(value interface{}) string {
+	var raw []byte
+	if s, ok := value.(string); ok {
+		raw = []byte(s)
+	} else {
+		raw, _ = json.Marshal(value)
+	}
+	sum := sha256.Sum256(raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,85 @@
+package process
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineRedactor(t *testing.T) {
+	email, err := ParseRule("email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom, err := ParseRule("session=sid-[0-9]+")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor := NewLineRedactor(email, custom)
+
+	data := redactor.Process([]byte("login a@b.io sid-1234 and c@d.com\n"))
+	expected := "login [REDACTED:email] [REDACTED:session] and [REDACTED:email]\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected redaction: %s", data)
+	}
+
+	counts := redactor.Counts()
+	if counts["email"] != 2 || counts["session"] != 1 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+	t.Log(redactor.Report())
+}
+
+func TestCardRule(t *testing.T) {
+	card, err := ParseRule("card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor := NewLineRedactor(card)
+
+	data := redactor.Process([]byte("paid 4111 1111 1111 1111 at 1508842344123\n"))
+	expected := "paid [REDACTED:card] at 1508842344123\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected redaction: %s", data)
+	}
+	if counts := redactor.Counts(); counts["card"] != 1 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	if _, err := ParseRule("unknown"); err != ErrUnknownRule {
+		t.Fatalf("expected ErrUnknownRule, got %v", err)
+	}
+	if _, err := ParseFieldRule("user.email=mask"); err != ErrUnknownAction {
+		t.Fatalf("expected ErrUnknownAction, got %v", err)
+	}
+}
+
+func TestJSONRedactor(t *testing.T) {
+	hashRule, err := ParseFieldRule("user.email=hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeRule, err := ParseFieldRule("token=remove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor := NewJSONRedactor(hashRule, removeRule)
+
+	data := redactor.Process([]byte(`{"user": {"email": "a@b.io"}, "token": "x", "count": 12345678901234567890}` + "\n"))
+	record := string(data)
+	if strings.Contains(record, "a@b.io") || strings.Contains(record, "token") {
+		t.Fatalf("fields are not redacted: %s", record)
+	}
+	if !strings.Contains(record, "sha256:") || !strings.Contains(record, "12345678901234567890") {
+		t.Fatalf("unexpected record: %s", record)
+	}
+	if !strings.HasSuffix(record, "\n") {
+		t.Fatal("trailing newline is lost")
+	}
+
+	raw := []byte("not a json\n")
+	if string(redactor.Process(raw)) != string(raw) {
+		t.Fatal("non json record must pass through")
+	}
+}
@@ -0,0 +1,81 @@
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+)
+
+// Sampler keeps a fraction of the records, randomly or by the hash of a json field
+// so the records sharing the same key are kept or dropped together
+type Sampler struct {
+	rate    float64
+	key     []string
+	mutex   *sync.Mutex
+	random  *rand.Rand
+	dropped uint64
+}
+
+// NewSampler returns a Sampler keeping the rate(0 to 1) of the records,
+// the key is a dotted path of a json field, records without the key are sampled randomly
+func NewSampler(rate float64, key string) *Sampler {
+	sampler := &Sampler{
+		rate:   rate,
+		mutex:  &sync.Mutex{},
+		random: rand.New(rand.NewSource(rand.Int63())),
+	}
+	if key != "" {
+		sampler.key = strings.Split(key, ".")
+	}
+	return sampler
+}
+
+// Process keeps or drops the record
+func (s *Sampler) Process(data []byte) []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var point float64
+	if value, ok := s.lookup(data); ok {
+		h := fnv.New32a()
+		_, _ = h.Write(value)
+		point = float64(h.Sum32()) / math.MaxUint32
+	} else {
+		point = s.random.Float64()
+	}
+
+	if point < s.rate {
+		return data
+	}
+	s.dropped++
+	Metrics.Add("sample.dropped", 1)
+	return nil
+}
+
+func (s *Sampler) lookup(data []byte) ([]byte, bool) {
+	if s.key == nil {
+		return nil, false
+	}
+
+	record, ok := decode(data)
+	if !ok {
+		return nil, false
+	}
+	object, ok := parent(record, s.key)
+	if !ok {
+		return nil, false
+	}
+	raw, err := json.Marshal(object[s.key[len(s.key)-1]])
+	return raw, err == nil
+}
+
+// Report formats the number of dropped records
+func (s *Sampler) Report() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fmt.Sprintf("sampling %.3f: dropped=%d", s.rate, s.dropped)
+}
@@ -0,0 +1,35 @@
+package process
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSamplerRandom(t *testing.T) {
+	sampler := NewSampler(0.5, "")
+
+	var kept int
+	for i := 0; i < 1000; i++ {
+		if sampler.Process([]byte("record\n")) != nil {
+			kept++
+		}
+	}
+	if kept < 400 || kept > 600 {
+		t.Fatalf("kept %d of 1000 records", kept)
+	}
+	t.Log(sampler.Report())
+}
+
+func TestSamplerKey(t *testing.T) {
+	sampler := NewSampler(0.5, "user.id")
+
+	for i := 0; i < 100; i++ {
+		record := []byte(fmt.Sprintf(`{"user": {"id": %d}, "seq": 1}`, i))
+		first := sampler.Process(record) != nil
+
+		record = []byte(fmt.Sprintf(`{"user": {"id": %d}, "seq": 2}`, i))
+		if second := sampler.Process(record) != nil; first != second {
+			t.Fatalf("records of the key %d are sampled differently", i)
+		}
+	}
+}
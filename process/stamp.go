@@ -0,0 +1,121 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// stamp formats of the Stamper
+const (
+	// StampFields adds the fields to the json object
+	StampFields = "fields"
+	// StampPrefix prefixes the line with the stamp separated by spaces
+	StampPrefix = "prefix"
+	// StampJSON wraps the line into a json object of the stamp and the line
+	StampJSON = "json"
+)
+
+// fields of the stamp
+const (
+	FieldIngestTime = "s4_ingest_time"
+	FieldHostname   = "s4_hostname"
+	FieldInput      = "s4_input"
+	FieldConn       = "s4_conn"
+	FieldSeq        = "s4_seq"
+	FieldLine       = "line"
+)
+
+var (
+	// ErrUnknownStamp unknown stamp format
+	ErrUnknownStamp = errors.New("unknown stamp format")
+)
+
+// Stamper stamps the records of a connection with the ingest time, the hostname,
+// the input name, the connection id and the sequence number of the record
+type Stamper struct {
+	format   string
+	hostname string
+	input    string
+	conn     uint64
+	seq      uint64
+	now      func() time.Time
+}
+
+// NewStamper returns a Stamper of a connection
+func NewStamper(format, input string, conn uint64) (*Stamper, error) {
+	switch format {
+	case StampFields, StampPrefix, StampJSON:
+	default:
+		return nil, ErrUnknownStamp
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stamper{
+		format:   format,
+		hostname: hostname,
+		input:    input,
+		conn:     conn,
+		now:      time.Now,
+	}
+	return s, nil
+}
+
+// Process stamps the record, a record that is not a json object passes through on the fields format
+func (s *Stamper) Process(data []byte) []byte {
+	s.seq++
+	ingestTime := s.now().UTC().Format(time.RFC3339Nano)
+
+	switch s.format {
+	case StampPrefix:
+		prefix := fmt.Sprintf("%s %s %s %d %d ", ingestTime, s.hostname, s.input, s.conn, s.seq)
+		return append([]byte(prefix), data...)
+	case StampJSON:
+		encoded, err := json.Marshal(map[string]interface{}{
+			FieldIngestTime: ingestTime,
+			FieldHostname:   s.hostname,
+			FieldInput:      s.input,
+			FieldConn:       s.conn,
+			FieldSeq:        s.seq,
+			FieldLine:       string(bytes.TrimSuffix(data, []byte("\n"))),
+		})
+		if err != nil {
+			return data
+		}
+		return append(encoded, '\n')
+	}
+
+	// the record is a single json object once the spaces around it are trimmed,
+	// a record with trailing data passes through
+	object := bytes.TrimSpace(data)
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(object, &members); err != nil || members == nil {
+		return data
+	}
+	// the fields are spliced before the closing brace to keep the members as they are
+	fields, _ := json.Marshal(map[string]interface{}{
+		FieldIngestTime: ingestTime,
+		FieldHostname:   s.hostname,
+		FieldInput:      s.input,
+		FieldConn:       s.conn,
+		FieldSeq:        s.seq,
+	})
+
+	stamped := make([]byte, 0, len(data)+len(fields))
+	stamped = append(stamped, object[:len(object)-1]...)
+	if len(members) > 0 {
+		stamped = append(stamped, ',')
+	}
+	stamped = append(stamped, fields[1:len(fields)-1]...)
+	stamped = append(stamped, '}')
+	if bytes.HasSuffix(data, []byte("\n")) {
+		stamped = append(stamped, '\n')
+	}
+	return stamped
+}
@@ -0,0 +1,67 @@
+package process
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestStamper(t *testing.T, format string) *Stamper {
+	s, err := NewStamper(format, "app", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.hostname = "host"
+	s.now = func() time.Time {
+		return time.Date(2017, 8, 3, 14, 5, 0, 0, time.UTC)
+	}
+	return s
+}
+
+func TestStampFields(t *testing.T) {
+	s := newTestStamper(t, StampFields)
+
+	stamped := string(s.Process([]byte(`{"message": "hello"}` + "\n")))
+	expected := `{"message": "hello","s4_conn":3,"s4_hostname":"host","s4_ingest_time":"2017-08-03T14:05:00Z","s4_input":"app","s4_seq":1}` + "\n"
+	if stamped != expected {
+		t.Fatalf("unexpected stamp: %s", stamped)
+	}
+
+	empty := string(s.Process([]byte("{}")))
+	if empty != `{"s4_conn":3,"s4_hostname":"host","s4_ingest_time":"2017-08-03T14:05:00Z","s4_input":"app","s4_seq":2}` {
+		t.Fatalf("unexpected stamp of the empty object: %s", empty)
+	}
+	spaced := string(s.Process([]byte(` {"message": "hello"} ` + "\n")))
+	if spaced != `{"message": "hello","s4_conn":3,"s4_hostname":"host","s4_ingest_time":"2017-08-03T14:05:00Z","s4_input":"app","s4_seq":3}`+"\n" {
+		t.Fatalf("unexpected stamp of the spaced object: %s", spaced)
+	}
+	for _, passed := range []string{"plain\n", `{"message": "hello"} trailing` + "\n", "{\"first\": 1}\n{\"second\": 2}\n", "[]\n", "null\n"} {
+		if stamped := string(s.Process([]byte(passed))); stamped != passed {
+			t.Fatalf("record that is not a json object is stamped: %s", stamped)
+		}
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(s.Process([]byte("{\n\"message\": \"multi\"\n}\n")), &record); err != nil || record[FieldSeq] == nil {
+		t.Fatalf("unexpected stamp of the multiline object: %v %v", record, err)
+	}
+}
+
+func TestStampLine(t *testing.T) {
+	prefix := newTestStamper(t, StampPrefix)
+	if stamped := string(prefix.Process([]byte("hello\n"))); stamped != "2017-08-03T14:05:00Z host app 3 1 hello\n" {
+		t.Fatalf("unexpected prefix: %s", stamped)
+	}
+
+	wrapper := newTestStamper(t, StampJSON)
+	stamped := wrapper.Process([]byte("hello\n"))
+	var record map[string]interface{}
+	if err := json.Unmarshal(stamped, &record); err != nil || record[FieldLine] != "hello" || !strings.HasSuffix(string(stamped), "\n") {
+		t.Fatalf("unexpected json: %s %v", stamped, err)
+	}
+
+	if _, err := NewStamper("xml", "app", 1); err != ErrUnknownStamp {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
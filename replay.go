@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/river"
+	"github.com/urfave/cli"
+)
+
+var (
+	timeLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"}
+
+	replayConfigFlag = []cli.Flag{
+		cli.StringFlag{
+			Name:  "source",
+			Usage: "s3 path written by s4 to replay, required",
+		},
+		cli.StringFlag{
+			Name:  "source-region",
+			Usage: "aws s3 region of the source, required",
+		},
+		cli.StringSliceFlag{
+			Name:  "source-envelope-key",
+			Usage: "master key of the source encrypted on the client side, \"id=file:/path\" or \"id=env:VARIABLE\"",
+		},
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "replay the objects partitioned at or after the time(2006-01-02, 2006-01-02T15:04 or RFC3339)",
+		},
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "replay the objects partitioned before the time",
+		},
+		cli.StringFlag{
+			Name:  "progress",
+			Value: "./replay.progress",
+			Usage: "path of the file recording the replayed objects to resume",
+		},
+		cli.StringFlag{
+			Name:  "buffer, b",
+			Value: "./replay",
+			Usage: "path of the replay buffer, it replaces the buffer of the configured pipeline and must not be a buffer of a running river",
+		},
+		cli.StringFlag{
+			Name:  "type, t",
+			Value: "line",
+			Usage: "define the buffer type that can be parsed format(json, line, memory)",
+		},
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "path of the configuration file, overrides the type, the processors and the sink",
+		},
+		cli.StringFlag{
+			Name:  "pipeline, p",
+			Usage: "pipeline name of the configuration file",
+		},
+		bufferKeyFlag,
+	}
+)
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func s4Replay(c *cli.Context) error {
+	source, region := c.String("source"), c.String("source-region")
+	if source == "" || region == "" {
+		return ErrOptionRequired
+	}
+	if c.String("config") == "" && (c.String("s3Path") == "" || c.String("region") == "") {
+		return ErrOptionRequired
+	}
+	from, err := parseTime(c.String("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseTime(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	bucket, key := path.Split(source)
+	reader, err := lake.OpenS3Reader(region, strings.TrimRight(bucket, "/"), key)
+	if err != nil {
+		return err
+	}
+	if reader.Keyring, err = crypt.ParseKeyring(c.StringSlice("source-envelope-key")); err != nil {
+		return err
+	}
+	objects, err := reader.List(from, to)
+	if err != nil {
+		return err
+	}
+
+	progress, err := lake.OpenProgress(c.String("progress"))
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
+	r, err := replayRiver(c)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, object := range objects {
+		if progress.Done(object.Key) {
+			continue
+		}
+
+		data, err := reader.Read(object.Key)
+		if err != nil {
+			return err
+		}
+		for len(data) > 0 {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				r.Flow(append(data, '\n'))
+				break
+			}
+			r.Flow(data[:i+1])
+			data = data[i+1:]
+		}
+
+		if err := replayFlush(r, object); err != nil {
+			return err
+		}
+		if err := progress.Mark(object.Key); err != nil {
+			return err
+		}
+		logger.Printf("Replayed %s", object.Key)
+	}
+	logger.Printf("Replayed %d objects", len(objects))
+	return nil
+}
+
+// replayRiver opens the river of the replay on its own buffer, the buffer of the configured pipeline
+// may be owned by a running s4. The records left by an interrupted replay are purged,
+// their object is not marked and it is replayed again
+func replayRiver(c *cli.Context) (river.River, error) {
+	var r river.River
+	var err error
+	if c.String("config") != "" {
+		r, err = configRiver(c, c.String("buffer"))
+	} else {
+		r, err = bufferRiver(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := r.Stat()
+	if err == nil && stat.Records > 0 {
+		logger.Printf("Purge %d records left in the replay buffer %s", stat.Records, c.String("buffer"))
+		err = r.Purge()
+	}
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// replayFlush pushes the replayed records to the partition of their source object,
+// the objects are named after it so that a replay of the object overwrites the objects of the previous one
+func replayFlush(r river.River, object *lake.Object) error {
+	for n := 0; ; n++ {
+		batch, err := r.Ready()
+		if err != nil || batch == nil {
+			return err
+		}
+		batch.Partition, batch.Name = object.Partition(), replayName(object.Key, n)
+		if err := r.Commit(context.Background(), batch); err != nil {
+			return err
+		}
+	}
+}
+
+// replayName returns the name of the n-th batch of the source object
+func replayName(key string, n int) string {
+	name := path.Base(key)
+	if n == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d.txt.gz", strings.TrimSuffix(name, ".txt.gz"), n)
+}
+
+func replayCommand() cli.Command {
+	return cli.Command{
+		Name:   "replay",
+		Flags:  flags(replayConfigFlag, s3ConfigFlag, processConfigFlag),
+		Usage:  "reprocess the objects written by s4 through a river to another sink",
+		Action: s4Replay,
+	}
+}
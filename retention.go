@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+
+	"github.com/findcoo/s4/config"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/pipeline"
+	"github.com/urfave/cli"
+)
+
+var retentionConfigFlag = []cli.Flag{
+	cli.StringFlag{
+		Name:   "s3Path, s",
+		Usage:  "s3 path written by s4, required without the configuration file",
+		EnvVar: "S4_S3_PATH",
+	},
+	cli.StringFlag{
+		Name:   "region, r",
+		Usage:  "aws s3 region, required without the configuration file",
+		EnvVar: "S4_REGION",
+	},
+	cli.DurationFlag{
+		Name:  "period",
+		Usage: "keep the partitions of the period(720h), the older days expire",
+	},
+	cli.StringFlag{
+		Name:  "storage-class",
+		Usage: "transition the expired objects to the storage class instead of deleting them",
+	},
+	cli.StringFlag{
+		Name:  "sse",
+		Usage: "server-side encryption of the transitioned objects(sse-s3, sse-kms)",
+	},
+	cli.StringFlag{
+		Name:  "sse-kms-key-id",
+		Usage: "kms key of the sse-kms encryption",
+	},
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "path of the configuration file, applies the retention of every s3 sink",
+	},
+	cli.StringFlag{
+		Name:  "pipeline, p",
+		Usage: "pipeline name of the configuration file, all pipelines by default",
+	},
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "print the expired objects without changing them",
+	},
+}
+
+// retentionSinks returns the sinks of the configuration file or of the flags
+func retentionSinks(c *cli.Context) ([]config.Sink, error) {
+	if confPath := c.String("config"); confPath != "" {
+		conf, err := config.Load(confPath)
+		if err != nil {
+			return nil, err
+		}
+		var sinks []config.Sink
+		found := c.String("pipeline") == ""
+		for _, pc := range conf.Pipelines {
+			if c.String("pipeline") != "" && pc.Name != c.String("pipeline") {
+				continue
+			}
+			found = true
+			sinks = append(sinks, pc.Sinks...)
+		}
+		if !found {
+			return nil, ErrPipelineNotFound
+		}
+		return sinks, nil
+	}
+
+	if c.String("s3Path") == "" || c.String("region") == "" || c.Duration("period") <= 0 {
+		return nil, ErrOptionRequired
+	}
+	sink := config.Sink{
+		Type:       config.SinkS3,
+		S3Path:     c.String("s3Path"),
+		Region:     c.String("region"),
+		Encryption: c.String("sse"),
+		KMSKeyID:   c.String("sse-kms-key-id"),
+		Retention: config.Retention{
+			Period:       c.Duration("period"),
+			StorageClass: c.String("storage-class"),
+		},
+	}
+	return []config.Sink{sink}, sink.Validate()
+}
+
+func s4Retention(c *cli.Context) error {
+	sinks, err := retentionSinks(c)
+	if err != nil {
+		return err
+	}
+
+	for _, sink := range sinks {
+		retention, err := pipeline.NewRetention(sink)
+		if err != nil {
+			return err
+		}
+		if retention == nil {
+			continue
+		}
+		retention.DryRun = retention.DryRun || c.Bool("dry-run")
+
+		objects, err := retention.Run(time.Now())
+		action := "Deleted"
+		if retention.StorageClass != "" {
+			action = "Transitioned to " + retention.StorageClass
+		}
+		if retention.DryRun {
+			action = "Expired"
+		}
+		for _, object := range objects {
+			logger.Printf("%s %s", action, object.Key)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Printf("%s %d objects of %s", action, len(objects), sink.S3Path)
+	}
+	return nil
+}
+
+func retentionCommand() cli.Command {
+	return cli.Command{
+		Name:   "retention",
+		Flags:  retentionConfigFlag,
+		Usage:  "delete or transition the objects of the partitions older than the retention period",
+		Action: s4Retention,
+	}
+}
@@ -0,0 +1,47 @@
+package river
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrStopWalk stops the walk of the buffer without error
+	ErrStopWalk = errors.New("stop walking the buffer")
+)
+
+// Buffer offline access to the buffer of a river
+type Buffer interface {
+	Stat() (*Stat, error)
+	Walk(fn func(offset uint64, record []byte) error) error
+	Flush() error
+	Purge() error
+}
+
+// Stat statistics of a buffer
+type Stat struct {
+	Records uint64 `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	Oldest  uint64 `json:"oldest"`
+	Newest  uint64 `json:"newest"`
+}
+
+// String formats the statistics
+func (s *Stat) String() string {
+	return fmt.Sprintf("records: %d, bytes: %d, oldest offset: %d, newest offset: %d", s.Records, s.Bytes, s.Oldest, s.Newest)
+}
+
+// statOf collects the statistics by walking the buffer
+func statOf(b Buffer) (*Stat, error) {
+	stat := &Stat{}
+	err := b.Walk(func(offset uint64, record []byte) error {
+		if stat.Records == 0 {
+			stat.Oldest = offset
+		}
+		stat.Newest = offset
+		stat.Records++
+		stat.Bytes += int64(len(record))
+		return nil
+	})
+	return stat, err
+}
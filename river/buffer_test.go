@@ -0,0 +1,248 @@
+package river
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/lake"
+)
+
+func testBuffer(t *testing.T, r River) {
+	defer r.Close()
+	r.Flow([]byte(`{"message": "hello"}` + "\n"))
+	r.Flow([]byte(`{"message": "world"}` + "\n"))
+
+	stat, err := r.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Records != 2 || stat.Bytes != 42 || stat.Newest-stat.Oldest != 1 {
+		t.Fatalf("unexpected stat: %s", stat)
+	}
+
+	var records []string
+	err = r.Walk(func(offset uint64, record []byte) error {
+		records = append(records, string(record))
+		return ErrStopWalk
+	})
+	if err != nil || len(records) != 1 || records[0] != `{"message": "hello"}`+"\n" {
+		t.Fatalf("unexpected walk: %v %v", records, err)
+	}
+
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	r.Flow([]byte(`{"message": "purge"}` + "\n"))
+	if err := r.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := r.Stat(); stat.Records != 0 {
+		t.Fatalf("buffer is not empty: %s", stat)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, rivertype := range []string{TypeLine, TypeJSON, TypeMemory} {
+		r, err := NewRiver(rivertype, &Config{
+			BufferPath: path.Join(dir, rivertype),
+			Supplyer:   lake.NewConsoleSupplyer(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testBuffer(t, r)
+	}
+}
+
+// captureSupplyer a sink keeping the pushed data and batches
+type captureSupplyer struct {
+	data    []byte
+	batches []*lake.Batch
+}
+
+func (cs *captureSupplyer) Push(data []byte) error {
+	return cs.Write(context.Background(), lake.NewBatch(data))
+}
+
+func (cs *captureSupplyer) Write(ctx context.Context, batch *lake.Batch) error {
+	cs.data = append(cs.data, batch.Data...)
+	cs.batches = append(cs.batches, batch)
+	return nil
+}
+
+func (cs *captureSupplyer) Flush(ctx context.Context) error { return nil }
+
+func (cs *captureSupplyer) Close() error { return nil }
+
+func TestBufferEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("S4_TEST_BUFFER_KEY", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("S4_TEST_BUFFER_KEY")
+	keyring, err := crypt.ParseKeyring([]string{"k1=env:S4_TEST_BUFFER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := `{"message": "confidential"}` + "\n"
+	for _, rivertype := range []string{TypeLine, TypeJSON} {
+		supplyer := &captureSupplyer{}
+		r, err := NewRiver(rivertype, &Config{
+			BufferPath: path.Join(dir, rivertype),
+			Keyring:    keyring,
+			Supplyer:   supplyer,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Flow([]byte(record))
+
+		_ = filepath.Walk(path.Join(dir, rivertype), func(name string, info os.FileInfo, err error) error {
+			if raw, _ := ioutil.ReadFile(name); bytes.Contains(raw, []byte("confidential")) {
+				t.Fatalf("%s: plain record in %s", rivertype, name)
+			}
+			return nil
+		})
+		_ = r.Walk(func(offset uint64, data []byte) error {
+			if string(data) != record {
+				t.Fatalf("%s: unexpected record %q", rivertype, data)
+			}
+			return nil
+		})
+		if err := r.Flush(); err != nil || string(supplyer.data) != record {
+			t.Fatalf("%s: unexpected push %q: %v", rivertype, supplyer.data, err)
+		}
+		_ = r.Close()
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	for _, rivertype := range []string{TypeLine, TypeJSON, TypeMemory} {
+		dir, err := ioutil.TempDir("", "s4-open")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		_, err = NewRiver(rivertype, &Config{
+			BufferPath:     path.Join(dir, rivertype),
+			OverflowPolicy: "unknown",
+		})
+		if err != ErrUnknownOverflow {
+			t.Fatalf("%s: unexpected error %v", rivertype, err)
+		}
+	}
+}
+
+func TestBufferKeyMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("S4_TEST_BUFFER_KEY", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("S4_TEST_BUFFER_KEY")
+	keyring, err := crypt.ParseKeyring([]string{"k1=env:S4_TEST_BUFFER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypt.ParseKeyring([]string{"k2=env:S4_TEST_BUFFER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := `{"message": "confidential"}` + "\n"
+	for _, rivertype := range []string{TypeLine, TypeJSON} {
+		bufferPath := path.Join(dir, rivertype)
+		r, err := NewRiver(rivertype, &Config{BufferPath: bufferPath, Keyring: keyring})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Flow([]byte(record))
+		_ = r.Close()
+
+		if _, err := NewRiver(rivertype, &Config{BufferPath: bufferPath}); err != crypt.ErrNoKeyring {
+			t.Fatalf("%s: buffer is opened without the key: %v", rivertype, err)
+		}
+		if _, err := NewRiver(rivertype, &Config{BufferPath: bufferPath, Keyring: other}); err != crypt.ErrUnknownKey {
+			t.Fatalf("%s: buffer is opened with another key: %v", rivertype, err)
+		}
+
+		supplyer := &captureSupplyer{}
+		r, err = NewRiver(rivertype, &Config{BufferPath: bufferPath, Keyring: keyring, Supplyer: supplyer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Flush(); err != nil || string(supplyer.data) != record {
+			t.Fatalf("%s: records are lost by the refused opens %q: %v", rivertype, supplyer.data, err)
+		}
+		_ = r.Close()
+	}
+}
+
+func TestBufferUndecryptable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("S4_TEST_BUFFER_KEY", "000102030405060708090a0b0c0d0e0f")
+	defer os.Unsetenv("S4_TEST_BUFFER_KEY")
+	keyring, err := crypt.ParseKeyring([]string{"k1=env:S4_TEST_BUFFER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypt.ParseKeyring([]string{"k2=env:S4_TEST_BUFFER_KEY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := other.Seal([]byte(`{"message": "unknown"}` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	supplyer := &captureSupplyer{}
+	jb := NewJSONRiver(&Config{BufferPath: path.Join(dir, "json"), Keyring: keyring, Supplyer: supplyer})
+	defer jb.Close()
+	jb.Flow([]byte(`{"message": "hello"}` + "\n"))
+	if err := jb.db.Put(offsetKey(2), sealed, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jb.Flush(); err == nil || supplyer.data != nil {
+		t.Fatalf("undecryptable record is not reported %q: %v", supplyer.data, err)
+	}
+	if batch, err := jb.Ready(); err == nil || batch != nil {
+		t.Fatalf("undecryptable buffer is taken %+v: %v", batch, err)
+	}
+	var records int
+	iter := jb.db.NewIterator(nil, nil)
+	for iter.Next() {
+		records++
+	}
+	iter.Release()
+	if records != 2 {
+		t.Fatalf("records are deleted by the failed reads: %d", records)
+	}
+	if err := jb.Purge(); err != nil {
+		t.Fatal(err)
+	}
+}
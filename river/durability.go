@@ -0,0 +1,86 @@
+package river
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/findcoo/s4/logger"
+)
+
+// durability policies of the buffers
+const (
+	// DurabilityNone leaves the writes to the page cache of the OS
+	DurabilityNone = "none"
+	// DurabilityInterval fsyncs the buffer every SyncInterval while it has unsynced writes
+	DurabilityInterval = "interval"
+	// DurabilityAlways fsyncs every record before Flow returns
+	DurabilityAlways = "always"
+
+	defaultSyncInterval = time.Millisecond * 100
+)
+
+var (
+	// ErrUnknownDurability unknown durability policy
+	ErrUnknownDurability = errors.New("unknown durability policy")
+)
+
+// syncer fsyncs a buffer periodically while it is dirty
+type syncer struct {
+	dirty int32
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newSyncer starts a syncer on the interval policy, it returns nil on the other policies
+func newSyncer(log *logger.Entry, durability string, interval time.Duration, sync func() error) (*syncer, error) {
+	switch durability {
+	case "", DurabilityNone, DurabilityAlways:
+		return nil, nil
+	case DurabilityInterval:
+	default:
+		return nil, ErrUnknownDurability
+	}
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+
+	s := &syncer{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if atomic.SwapInt32(&s.dirty, 0) == 0 {
+					continue
+				}
+				if err := sync(); err != nil {
+					log.Errorf("Sync the buffer: %v", err)
+				}
+			}
+		}
+	}()
+	return s, nil
+}
+
+// mark marks the buffer as dirty
+func (s *syncer) mark() {
+	if s != nil {
+		atomic.StoreInt32(&s.dirty, 1)
+	}
+}
+
+// close stops the syncer
+func (s *syncer) close() {
+	if s != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
@@ -0,0 +1,78 @@
+package river
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/findcoo/s4/lake"
+)
+
+func benchmarkDurability(b *testing.B, rivertype, durability string) {
+	dir, err := ioutil.TempDir("", "s4-durability")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRiver(rivertype, &Config{
+		BufferPath:   dir + "/buffer",
+		Durability:   durability,
+		SyncInterval: time.Millisecond * 100,
+		Supplyer:     lake.NewConsoleSupplyer(),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+
+	record := []byte(`{"message": "hello world"}` + "\n")
+	b.SetBytes(int64(len(record)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Flow(record)
+	}
+}
+
+func BenchmarkDurability(b *testing.B) {
+	for _, rivertype := range []string{TypeLine, TypeJSON} {
+		for _, durability := range []string{DurabilityNone, DurabilityInterval, DurabilityAlways} {
+			b.Run(rivertype+"/"+durability, func(b *testing.B) {
+				benchmarkDurability(b, rivertype, durability)
+			})
+		}
+	}
+}
+
+func TestSyncer(t *testing.T) {
+	synced := make(chan struct{}, 1)
+	s, err := newSyncer(nil, DurabilityInterval, time.Millisecond, func() error {
+		synced <- struct{}{}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	select {
+	case <-synced:
+		t.Fatal("clean buffer is synced")
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	s.mark()
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		t.Fatal("dirty buffer is not synced")
+	}
+
+	if s, _ := newSyncer(nil, DurabilityAlways, time.Millisecond, nil); s != nil {
+		t.Fatal("syncer of the always policy")
+	}
+	if _, err := newSyncer(nil, "sometimes", time.Millisecond, nil); err != ErrUnknownDurability {
+		t.Fatalf("unknown durability is accepted: %v", err)
+	}
+}
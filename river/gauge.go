@@ -0,0 +1,103 @@
+package river
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/findcoo/s4/logger"
+)
+
+// overflow policies when the buffer reaches the MaxBufferSize
+const (
+	OverflowBlock      = "block"
+	OverflowDropNewest = "drop-newest"
+	OverflowDropOldest = "drop-oldest"
+)
+
+var (
+	// ErrUnknownOverflow unknown overflow policy
+	ErrUnknownOverflow = errors.New("unknown overflow policy")
+)
+
+// gauge tracks the size of the buffer and applies the overflow policy
+type gauge struct {
+	max     int64
+	size    int64
+	policy  string
+	dropped uint64
+	cond    *sync.Cond
+	log     *logger.Entry
+}
+
+func newGauge(log *logger.Entry, max, size int64, policy string) (*gauge, error) {
+	switch policy {
+	case "":
+		policy = OverflowBlock
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+	default:
+		return nil, ErrUnknownOverflow
+	}
+
+	g := &gauge{
+		max:    max,
+		size:   size,
+		policy: policy,
+		cond:   sync.NewCond(&sync.Mutex{}),
+		log:    log,
+	}
+	return g, nil
+}
+
+// acquire reserves n bytes of the buffer, blocks until a flush frees space on the block policy.
+// it returns false when the record has to be dropped
+// and the excess bytes that the caller has to evict on the drop-oldest policy
+func (g *gauge) acquire(n int) (bool, int64) {
+	g.cond.L.Lock()
+	defer g.cond.L.Unlock()
+
+	size := int64(n)
+	if g.max <= 0 || g.size == 0 || g.size+size <= g.max {
+		g.size += size
+		return true, 0
+	}
+
+	switch g.policy {
+	case OverflowDropNewest:
+		g.dropped++
+		if g.dropped == 1 || g.dropped%1000 == 0 {
+			g.log.Warnf("buffer is full, dropped newest records: %d", g.dropped)
+		}
+		return false, 0
+	case OverflowDropOldest:
+		excess := g.size + size - g.max
+		g.size += size
+		return true, excess
+	}
+
+	for g.size != 0 && g.size+size > g.max {
+		g.cond.Wait()
+	}
+	g.size += size
+	return true, 0
+}
+
+// release frees n bytes of the buffer and wakes up the blocked inputs
+func (g *gauge) release(n int64) {
+	g.cond.L.Lock()
+	g.size -= n
+	if g.size < 0 {
+		g.size = 0
+	}
+	g.cond.L.Unlock()
+	g.cond.Broadcast()
+}
+
+// evicted records the drop of the oldest records
+func (g *gauge) evicted(records int, n int64) {
+	g.release(n)
+
+	g.cond.L.Lock()
+	g.dropped += uint64(records)
+	g.log.Warnf("buffer is full, dropped oldest records: %d", g.dropped)
+	g.cond.L.Unlock()
+}
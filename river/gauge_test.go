@@ -0,0 +1,52 @@
+package river
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGaugeBlock(t *testing.T) {
+	g, err := newGauge(nil, 10, 0, OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := g.acquire(8); !ok {
+		t.Fatal("empty buffer must admit")
+	}
+
+	admitted := make(chan struct{})
+	go func() {
+		g.acquire(4)
+		admitted <- struct{}{}
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("full buffer must block")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	g.release(8)
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("flush must resume the blocked input")
+	}
+}
+
+func TestGaugeDrop(t *testing.T) {
+	newest, _ := newGauge(nil, 10, 8, OverflowDropNewest)
+	if ok, _ := newest.acquire(4); ok {
+		t.Fatal("drop-newest must drop the record")
+	}
+
+	oldest, _ := newGauge(nil, 10, 8, OverflowDropOldest)
+	ok, excess := oldest.acquire(4)
+	if !ok || excess != 2 {
+		t.Fatalf("drop-oldest must admit with excess 2, got %v %d", ok, excess)
+	}
+	oldest.evicted(1, 2)
+	if oldest.size != 10 {
+		t.Fatalf("unexpected size %d", oldest.size)
+	}
+}
@@ -1,116 +1,365 @@
 package river
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"strconv"
 	"sync"
 
 	"github.com/findcoo/s4/input"
+	"github.com/findcoo/s4/lake"
 	"github.com/findcoo/stream"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 // JSONRiver handling json data
 type JSONRiver struct {
-	db     *leveldb.DB
-	mutex  *sync.Mutex
-	offset uint64
+	db           *leveldb.DB
+	writeOptions *opt.WriteOptions
+	mutex        *sync.Mutex
+	gauge        *gauge
+	syncer       *syncer
+	offset       uint64
+	// inflight the batches taken by Ready until they are committed
+	inflight map[*lake.Batch]bool
+	// failed the batches in flight whose push failed, Ready takes them again first
+	failed []*lake.Batch
 	*Config
 }
 
-// NewJSONRiver returns a JSONRiver
+// syncKey is never written, a synced delete of it fsyncs the journal of LevelDB
+var syncKey = []byte("~sync")
+
+// offsetKey formats the offset as a fixed width key so that LevelDB keeps the records in order
+func offsetKey(offset uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", offset))
+}
+
+// NewJSONRiver returns a JSONRiver, it exits the process on a failure
 func NewJSONRiver(config *Config) *JSONRiver {
-	log.Print("Create the JSON-river")
+	jb, err := OpenJSONRiver(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return jb
+}
+
+// OpenJSONRiver returns a JSONRiver
+func OpenJSONRiver(config *Config) (*JSONRiver, error) {
+	config.Logger.Infof("Create the JSON-river")
 	options := &opt.Options{
 		Filter: filter.NewBloomFilter(10),
 	}
 
-	ldb, err := leveldb.OpenFile(config.BufferPath, options)
+	ldb, err := openLevelDB(config.Logger, config.BufferPath, options)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	migrated, err := migrateLevelDB(ldb)
+	if err != nil && !errors.IsCorrupted(err) {
+		_ = ldb.Close()
+		return nil, err
+	}
+	if migrated > 0 {
+		config.Logger.Infof("Migrate %d records of the buffer %s to the ordered keys", migrated, config.BufferPath)
 	}
+	records, size, offset, err := scanLevelDB(ldb, config.Keyring)
+	if err != nil && !errors.IsCorrupted(err) {
+		_ = ldb.Close()
+		return nil, err
+	}
+	if err != nil {
+		_ = ldb.Close()
+		if ldb, err = freshLevelDB(config.Logger, config.BufferPath, options, err); err != nil {
+			return nil, err
+		}
+		records, size, offset = 0, 0, 0
+	}
+	if records > 0 {
+		config.Logger.Infof("Open the buffer %s with %d records", config.BufferPath, records)
+	}
+
 	jb := &JSONRiver{
-		db:     ldb,
-		mutex:  &sync.Mutex{},
-		Config: config,
+		db:           ldb,
+		writeOptions: &opt.WriteOptions{Sync: config.Durability == DurabilityAlways},
+		mutex:        &sync.Mutex{},
+		offset:       offset,
+		inflight:     make(map[*lake.Batch]bool),
+		Config:       config,
 	}
-	return jb
+	if jb.gauge, err = newGauge(config.Logger, config.MaxBufferSize, size, config.OverflowPolicy); err != nil {
+		_ = ldb.Close()
+		return nil, err
+	}
+	jb.syncer, err = newSyncer(config.Logger, config.Durability, config.SyncInterval, func() error {
+		return ldb.Delete(syncKey, &opt.WriteOptions{Sync: true})
+	})
+	if err != nil {
+		_ = ldb.Close()
+		return nil, err
+	}
+	return jb, nil
 }
 
 // Connect wrapping the accept that read a byte slice from the unix server
-func (jb *JSONRiver) Connect() *input.UnixSocket {
-	return connect(jb.SocketPath, jb.Flow)
+func (jb *JSONRiver) Connect() (*input.UnixSocket, error) {
+	return connect(jb.Config, jb.Flow)
 }
 
 // Listen wrapping the listen that read a byte slice from the unix client
-func (jb *JSONRiver) Listen() func() {
-	return listen(jb.SocketPath, jb.Flow)
+func (jb *JSONRiver) Listen() (func(), error) {
+	return listen(jb.Config, jb.Flow)
 }
 
-// Consume consumes a byte slice from levelDB
+// Consume consumes a byte slice from levelDB, the records of a batch are deleted once it is pushed
 func (jb *JSONRiver) Consume() *stream.BytesStream {
+	flush := func() {
+		if jb.KeepBuffer {
+			return
+		}
+		if err := jb.Flush(); err != nil {
+			jb.Logger.Errorf("Flush the buffer: %v", err)
+		}
+	}
+	return consume(jb, jb.Config, flush)
+}
+
+// Close closes levelDB
+func (jb *JSONRiver) Close() error {
+	jb.syncer.close()
+	jb.mutex.Lock()
+	defer jb.mutex.Unlock()
+	return jb.db.Close()
+}
+
+// Ready takes the records of levelDB that are not in flight as a batch, nil when there is none,
+// the records are in flight until the batch is committed and a record that cannot be decrypted fails the read
+func (jb *JSONRiver) Ready() (*lake.Batch, error) {
+	jb.mutex.Lock()
+	defer jb.mutex.Unlock()
+
+	// a failed batch is taken again as it is so that the objects it put are not put again
+	if len(jb.failed) > 0 {
+		batch := jb.failed[0]
+		jb.failed = jb.failed[1:]
+		return batch, nil
+	}
+
 	var corpus []byte
+	var firstOffset, lastOffset uint64
 	iter := jb.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		offset, _ := strconv.ParseUint(string(iter.Key()), 10, 64)
+		if jb.isInflight(offset) {
+			// the offsets of a batch are contiguous
+			if corpus != nil {
+				break
+			}
+			continue
+		}
+		record, err := unseal(jb.Keyring, offset, iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		if corpus == nil {
+			firstOffset = offset
+		}
+		corpus = append(corpus, record...)
+		lastOffset = offset
+	}
+	if err := iter.Error(); err != nil || corpus == nil {
+		return nil, err
+	}
 
-	flush := func() {
-		_ = jb.Push(corpus)
-	}
-	bs, ticker := readyConsume(flush, jb.FlushIntervalTime)
-
-	bs.Target = func() {
-	PubLoop:
-		for {
-			iter = jb.db.NewIterator(nil, nil)
-			select {
-			case <-bs.AfterCancel():
-				break PubLoop
-			case <-ticker.C:
-				jb.mutex.Lock()
-				if corpus != nil {
-					bs.Send(corpus)
-					log.Printf("check offset: %d", jb.offset)
-					corpus = nil
-					jb.offset = 0
-				}
-				jb.mutex.Unlock()
-			default:
-				jb.mutex.Lock()
-				for iter.Next() {
-					corpus = append(corpus, iter.Value()...)
-					if err := jb.db.Delete(iter.Key(), nil); err != nil {
-						log.Fatal(err)
-					}
-				}
-				iter.Release()
-				if err := iter.Error(); err != nil {
-					log.Fatal(err)
-				}
-				jb.mutex.Unlock()
+	batch := lake.NewBatch(corpus)
+	batch.Source, batch.FirstOffset, batch.LastOffset = jb.BufferPath, firstOffset, lastOffset
+	jb.inflight[batch] = true
+	return batch, nil
+}
+
+// Commit pushes the batch taken by Ready and deletes its records after the push succeeds,
+// the records are taken again by a later Ready when the push fails
+func (jb *JSONRiver) Commit(ctx context.Context, batch *lake.Batch) error {
+	err := lake.PushBatch(ctx, jb.Supplyer, batch)
+	log := jb.Logger.With("records", batch.Records, "first_offset", batch.FirstOffset, "last_offset", batch.LastOffset)
+	if err != nil {
+		log.Errorf("Push the batch: %v", err)
+	} else {
+		log.Debugf("Push the batch of %d bytes", len(batch.Data))
+	}
+
+	if rerr := jb.release(batch, err == nil); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// release ends the flight of the batch, the records of the acknowledged batch are deleted,
+// the records evicted or purged in the meantime are already gone
+func (jb *JSONRiver) release(batch *lake.Batch, ack bool) error {
+	jb.mutex.Lock()
+	defer jb.mutex.Unlock()
+
+	if !jb.inflight[batch] {
+		return ErrUnknownBatch
+	}
+	if !ack {
+		jb.failed = append(jb.failed, batch)
+		return nil
+	}
+	delete(jb.inflight, batch)
+	jb.failed = dropBatch(jb.failed, batch)
+
+	var size int64
+	deletes := new(leveldb.Batch)
+	iter := jb.db.NewIterator(&util.Range{Start: offsetKey(batch.FirstOffset), Limit: offsetKey(batch.LastOffset + 1)}, nil)
+	for iter.Next() {
+		size += int64(len(iter.Value()))
+		deletes.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := jb.db.Write(deletes, nil); err != nil {
+		return err
+	}
+	jb.gauge.release(size)
+	return nil
+}
+
+// isInflight reports whether the record of the offset belongs to a batch in flight
+func (jb *JSONRiver) isInflight(offset uint64) bool {
+	for batch := range jb.inflight {
+		if offset >= batch.FirstOffset && offset <= batch.LastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// evict deletes the oldest records until the excess bytes are freed
+func (jb *JSONRiver) evict(excess int64) {
+	var freed int64
+	var records int
+	batch := new(leveldb.Batch)
+	iter := jb.db.NewIterator(nil, nil)
+	for freed < excess && iter.Next() {
+		freed += int64(len(iter.Value()))
+		records++
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		panic(err)
+	}
+	if err := jb.db.Write(batch, nil); err != nil {
+		panic(err)
+	}
+	jb.gauge.evicted(records, freed)
+}
+
+// Stat returns the statistics of levelDB
+func (jb *JSONRiver) Stat() (*Stat, error) {
+	return statOf(jb)
+}
+
+// Walk calls fn with each record of levelDB in order of the offset
+func (jb *JSONRiver) Walk(fn func(offset uint64, record []byte) error) error {
+	iter := jb.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		offset, _ := strconv.ParseUint(string(iter.Key()), 10, 64)
+		record, err := jb.Keyring.Open(iter.Value())
+		if err != nil {
+			return err
+		}
+		if err := fn(offset, record); err != nil {
+			if err == ErrStopWalk {
+				return nil
 			}
+			return err
 		}
 	}
-	return bs.Publish(nil)
+	return iter.Error()
+}
+
+// Flush pushes the records of levelDB that are not in flight to the Supplyer, the records are kept when the push fails
+func (jb *JSONRiver) Flush() error {
+	return FlushContext(context.Background(), jb)
+}
+
+// Purge discards the records of levelDB, the records that cannot be decrypted as well
+func (jb *JSONRiver) Purge() error {
+	jb.mutex.Lock()
+	defer jb.mutex.Unlock()
+
+	var size int64
+	batch := new(leveldb.Batch)
+	iter := jb.db.NewIterator(nil, nil)
+	for iter.Next() {
+		size += int64(len(iter.Value()))
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	if err := jb.db.Write(batch, nil); err != nil {
+		return err
+	}
+	jb.gauge.release(size)
+	for _, batch := range jb.failed {
+		delete(jb.inflight, batch)
+	}
+	jb.failed = nil
+	return nil
 }
 
 // Flow writes the byte slice that can be json to LevelDB
 func (jb *JSONRiver) Flow(data []byte) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Print(r)
+			jb.Logger.Errorf("Drop the record: %v", r)
 		}
 	}()
 
+	data = jb.Processors.Process(data)
+	if data == nil {
+		return
+	}
+
 	var validator map[string]interface{}
 	if err := json.Unmarshal(data, &validator); err != nil {
-		log.Panic(err)
+		panic(err)
 	}
 
+	sealed, err := jb.Keyring.Seal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	ok, excess := jb.gauge.acquire(len(sealed))
+	if !ok {
+		return
+	}
+
+	jb.mutex.Lock()
+	defer jb.mutex.Unlock()
+	if excess > 0 {
+		jb.evict(excess)
+	}
 	jb.offset++
-	key := strconv.FormatUint(jb.offset, 10)
-	if err := jb.db.Put([]byte(key), data, nil); err != nil {
-		log.Panic(err)
+	if err := jb.db.Put(offsetKey(jb.offset), sealed, jb.writeOptions); err != nil {
+		panic(err)
 	}
+	jb.syncer.mark()
 }
@@ -1,7 +1,10 @@
 package river
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
+	"os"
 	"testing"
 	"time"
 
@@ -32,7 +35,10 @@ func TestJSONFlow(t *testing.T) {
 
 func TestJSONConnect(t *testing.T) {
 	<-test.UnixTestServer(jsonRiver.SocketPath)
-	us := jsonRiver.Connect()
+	us, err := jsonRiver.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(time.Second * 2)
 	us.Cancel()
 }
@@ -64,10 +70,53 @@ func TestJSONConsume(t *testing.T) {
 func BenchmarkJSON(b *testing.B) {
 	ready, _ := test.UnixBenchmarkServer(10, jsonRiver.SocketPath)
 	<-ready
-	jsonRiver.Connect()
+	if _, err := jsonRiver.Connect(); err != nil {
+		b.Fatal(err)
+	}
 
 	consumer := jsonRiver.Consume()
 	consumer.Subscribe(func(data []byte) {
 		log.Print(string(data))
 	})
 }
+
+func TestJSONCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &captureSupplyer{}
+	jb := NewJSONRiver(&Config{BufferPath: dir, Supplyer: sink})
+	defer jb.Close()
+
+	jb.Flow([]byte(`{"message": "first"}` + "\n"))
+	first, err := jb.Ready()
+	if err != nil || first == nil || first.FirstOffset != 1 || first.LastOffset != 1 {
+		t.Fatalf("unexpected batch %+v: %v", first, err)
+	}
+	jb.Flow([]byte(`{"message": "second"}` + "\n"))
+	second, err := jb.Ready()
+	if err != nil || second == nil || second.FirstOffset != 2 {
+		t.Fatalf("the batch in flight is taken again %+v: %v", second, err)
+	}
+
+	jb.Supplyer = failSupplyer{}
+	if err := jb.Commit(context.Background(), first); err == nil {
+		t.Fatal("push failure is not returned")
+	}
+	if stat, _ := jb.Stat(); stat.Records != 2 {
+		t.Fatalf("records are deleted before the push succeeds: %s", stat)
+	}
+	jb.Supplyer = sink
+	if err := jb.Commit(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+	if err := jb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := jb.Stat(); stat.Records != 0 || len(sink.batches) != 2 || sink.batches[1].FirstOffset != 1 {
+		t.Fatalf("the failed batch is not pushed again: %s %d", stat, len(sink.batches))
+	}
+}
@@ -1,20 +1,28 @@
 package river
 
 import (
-	"io/ioutil"
+	"bufio"
+	"context"
 	"log"
 	"os"
-	"path"
 	"sync"
 
 	"github.com/findcoo/s4/input"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/wal"
 	"github.com/findcoo/stream"
 )
 
 // LineRiver flow with "\n"
 type LineRiver struct {
-	file  *os.File
-	mutex *sync.Mutex
+	log    *wal.Log
+	mutex  *sync.Mutex
+	gauge  *gauge
+	syncer *syncer
+	// inflight the segments of the batches taken by Ready until they are committed
+	inflight map[*lake.Batch][]wal.Segment
+	// failed the batches in flight whose push failed, Ready takes them again first
+	failed []*lake.Batch
 	*Config
 }
 
@@ -22,84 +30,397 @@ func defaultBufferPath() string {
 	return os.Getenv("HOME") + "/.s4/tmp"
 }
 
-// NewLineRiver returns a LineRiver
+// NewLineRiver returns a LineRiver, the buffer path is the directory of the segmented log,
+// it exits the process on a failure
 func NewLineRiver(config *Config) *LineRiver {
-	log.Print("Create the Line-river")
+	lr, err := OpenLineRiver(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return lr
+}
+
+// OpenLineRiver returns a LineRiver, the buffer path is the directory of the segmented log
+func OpenLineRiver(config *Config) (*LineRiver, error) {
+	config.Logger.Infof("Create the Line-river")
 	if config.BufferPath == "" {
 		config.BufferPath = defaultBufferPath()
 	}
-
-	dirname := path.Dir(config.BufferPath)
-	if err := os.Mkdir(dirname, 0755); err != nil && !os.IsExist(err) {
-		log.Fatal(err)
+	legacy, err := moveLegacyBuffer(config.BufferPath)
+	if err != nil {
+		return nil, err
 	}
 
-	f, err := os.OpenFile(config.BufferPath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	options := wal.Options{
+		SegmentSize: config.SegmentSize,
+		SegmentAge:  config.SegmentAge,
+		SyncAppend:  config.Durability == DurabilityAlways,
+	}
+	wl, err := wal.Open(config.BufferPath, options)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := recoverLog(config.Logger, config.BufferPath, wl); err != nil {
+		_ = wl.Close()
+		return nil, err
+	}
+	if err := checkKeys(config.Keyring, wl.Walk); err != nil {
+		_ = wl.Close()
+		return nil, err
 	}
 
 	lr := &LineRiver{
-		file:   f,
-		mutex:  &sync.Mutex{},
-		Config: config,
+		log:      wl,
+		mutex:    &sync.Mutex{},
+		inflight: make(map[*lake.Batch][]wal.Segment),
+		Config:   config,
 	}
-	return lr
+	if legacy != "" {
+		if err := lr.importLegacyBuffer(legacy); err != nil {
+			_ = wl.Close()
+			return nil, err
+		}
+	}
+	if lr.gauge, err = newGauge(config.Logger, config.MaxBufferSize, wl.Size(), config.OverflowPolicy); err != nil {
+		_ = wl.Close()
+		return nil, err
+	}
+	if lr.syncer, err = newSyncer(config.Logger, config.Durability, config.SyncInterval, wl.Sync); err != nil {
+		_ = wl.Close()
+		return nil, err
+	}
+	return lr, nil
+}
+
+// moveLegacyBuffer moves the single file buffer of the previous version out of the way of the log directory
+func moveLegacyBuffer(bufferPath string) (string, error) {
+	info, err := os.Stat(bufferPath)
+	if err != nil || info.IsDir() {
+		return "", nil
+	}
+
+	legacy := bufferPath + ".legacy"
+	return legacy, os.Rename(bufferPath, legacy)
+}
+
+func (lr *LineRiver) importLegacyBuffer(legacy string) error {
+	f, err := os.Open(legacy)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines int
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			sealed, err := lr.Keyring.Seal(line)
+			if err != nil {
+				return err
+			}
+			if _, err := lr.log.Append(sealed); err != nil {
+				return err
+			}
+			lines++
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err := os.Remove(legacy); err != nil {
+		return err
+	}
+	lr.Logger.Infof("Import %d lines of the legacy buffer %s", lines, legacy)
+	return nil
 }
 
 // Connect wrapping the accept
-func (lr *LineRiver) Connect() *input.UnixSocket {
-	return connect(lr.SocketPath, lr.Flow)
+func (lr *LineRiver) Connect() (*input.UnixSocket, error) {
+	return connect(lr.Config, lr.Flow)
 }
 
 // Listen wrapping the listen
-func (lr *LineRiver) Listen() func() {
-	return listen(lr.SocketPath, lr.Flow)
+func (lr *LineRiver) Listen() (func(), error) {
+	return listen(lr.Config, lr.Flow)
 }
 
-// Consume returns the *stream.BytesStream
+// Consume returns the *stream.BytesStream, the segments of a batch are deleted once it is pushed
 func (lr *LineRiver) Consume() *stream.BytesStream {
 	flush := func() {
-		data, _ := ioutil.ReadAll(lr.file)
-		_ = lr.Push(data)
-	}
-	bs, ticker := readyConsume(flush, lr.FlushIntervalTime)
-
-	bs.Target = func() {
-	PubLoop:
-		for {
-			select {
-			case <-bs.AfterCancel():
-				break PubLoop
-			case <-ticker.C:
-				data, err := ioutil.ReadFile(lr.BufferPath)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				lenOfSended := len(data)
-				if lenOfSended > 0 {
-					bs.Send(data)
-					log.Printf("length of sended bytes to streams %d", lenOfSended)
-				}
-				if err := lr.file.Truncate(0); err != nil {
-					log.Fatal(err)
-				}
+		if lr.KeepBuffer {
+			return
+		}
+		if err := lr.Flush(); err != nil {
+			lr.Logger.Errorf("Flush the buffer: %v", err)
+		}
+	}
+	return consume(lr, lr.Config, flush)
+}
+
+// Ready seals the active segment and takes the sealed segments that are not in flight as a batch,
+// nil when there is nothing to push, the segments are in flight until the batch is committed
+func (lr *LineRiver) Ready() (*lake.Batch, error) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+
+	// a failed batch is taken again as it is so that the objects it put are not put again
+	if len(lr.failed) > 0 {
+		batch := lr.failed[0]
+		lr.failed = lr.failed[1:]
+		return batch, nil
+	}
+	if err := lr.log.Seal(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var last uint64
+	var segments []wal.Segment
+	for _, segment := range lr.log.Sealed() {
+		if lr.isInflight(segment) {
+			// the offsets of a batch are contiguous
+			if len(segments) > 0 {
+				break
 			}
+			continue
+		}
+		records, offset, err := lr.read(segment)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, records...)
+		if offset > last {
+			last = offset
+		}
+		segments = append(segments, segment)
+	}
+
+	if len(data) == 0 {
+		for _, segment := range segments {
+			if err := lr.log.Remove(segment); err != nil {
+				return nil, err
+			}
+			lr.gauge.release(segment.Size)
+		}
+		return nil, nil
+	}
+	batch := lake.NewBatch(data)
+	batch.Source, batch.FirstOffset, batch.LastOffset = lr.BufferPath, segments[0].First, last
+	lr.inflight[batch] = segments
+	return batch, nil
+}
+
+// read returns the decrypted records of the segment and the offset of the last one,
+// the records after a corruption are skipped and a record that cannot be decrypted fails the read
+func (lr *LineRiver) read(segment wal.Segment) ([]byte, uint64, error) {
+	var data []byte
+	var last uint64
+	err := segment.Walk(func(offset uint64, record []byte) error {
+		opened, err := unseal(lr.Keyring, offset, record)
+		if err != nil {
+			return err
+		}
+		data = append(data, opened...)
+		last = offset
+		return nil
+	})
+	if err == wal.ErrCorrupted {
+		lr.Logger.Warnf("%s: %v, the rest of the segment is skipped", segment.Path, err)
+		err = nil
+	}
+	return data, last, err
+}
+
+// Commit pushes the batch taken by Ready and deletes its segments after the push succeeds,
+// the segments are taken again by a later Ready when the push fails
+func (lr *LineRiver) Commit(ctx context.Context, batch *lake.Batch) error {
+	err := lake.PushBatch(ctx, lr.Supplyer, batch)
+	log := lr.Logger.With("records", batch.Records, "first_offset", batch.FirstOffset, "last_offset", batch.LastOffset)
+	if err != nil {
+		log.Errorf("Push the batch: %v", err)
+	} else {
+		log.Debugf("Push the batch of %d bytes", len(batch.Data))
+	}
+
+	if rerr := lr.release(batch, err == nil); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// release ends the flight of the batch, the segments of the acknowledged batch are deleted
+func (lr *LineRiver) release(batch *lake.Batch, ack bool) error {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+
+	segments, ok := lr.inflight[batch]
+	if !ok {
+		return ErrUnknownBatch
+	}
+	if !ack {
+		lr.failed = append(lr.failed, batch)
+		return nil
+	}
+	delete(lr.inflight, batch)
+	lr.failed = dropBatch(lr.failed, batch)
+
+	sealed := lr.log.Sealed()
+	for _, segment := range segments {
+		// a purge removes the segments in flight
+		if !containsSegment(sealed, segment) {
+			continue
+		}
+		if err := lr.log.Remove(segment); err != nil {
+			return err
+		}
+		lr.gauge.release(segment.Size)
+	}
+	return nil
+}
+
+// Push pushes the data to the Supplyer, the buffer is left as it is
+func (lr *LineRiver) Push(data []byte) error {
+	return lake.PushBatch(context.Background(), lr.Supplyer, lake.NewBatch(data))
+}
+
+// Close closes the segmented log
+func (lr *LineRiver) Close() error {
+	lr.syncer.close()
+	return lr.log.Close()
+}
+
+// evict removes the oldest segments until the excess bytes are freed,
+// the active segment is sealed and removed when the sealed ones are not enough
+func (lr *LineRiver) evict(excess int64) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+
+	segments := lr.log.Sealed()
+	var sealedSize int64
+	for _, segment := range segments {
+		sealedSize += segment.Size
+	}
+	if sealedSize < excess {
+		if err := lr.log.Seal(); err != nil {
+			panic(err)
+		}
+		segments = lr.log.Sealed()
+	}
+
+	var freed int64
+	var records int
+	for _, segment := range segments {
+		if freed >= excess {
+			break
+		}
+		if lr.isInflight(segment) {
+			continue
+		}
+		_ = segment.Walk(func(uint64, []byte) error {
+			records++
+			return nil
+		})
+		if err := lr.log.Remove(segment); err != nil {
+			panic(err)
+		}
+		freed += segment.Size
+	}
+	lr.gauge.evicted(records, freed)
+}
+
+// isInflight reports whether the segment belongs to a batch in flight
+func (lr *LineRiver) isInflight(segment wal.Segment) bool {
+	for _, segments := range lr.inflight {
+		if containsSegment(segments, segment) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSegment(segments []wal.Segment, segment wal.Segment) bool {
+	for _, s := range segments {
+		if s.First == segment.First {
+			return true
+		}
+	}
+	return false
+}
+
+// Stat returns the statistics of the segmented log
+func (lr *LineRiver) Stat() (*Stat, error) {
+	return statOf(lr)
+}
+
+// Walk calls fn with each record of the segmented log
+func (lr *LineRiver) Walk(fn func(offset uint64, record []byte) error) error {
+	err := lr.log.Walk(func(offset uint64, record []byte) error {
+		opened, err := lr.Keyring.Open(record)
+		if err != nil {
+			return err
+		}
+		return fn(offset, opened)
+	})
+	if err == ErrStopWalk {
+		return nil
+	}
+	return err
+}
+
+// Flush pushes the segments that are not in flight to the Supplyer, the segments are kept when the push fails
+func (lr *LineRiver) Flush() error {
+	return FlushContext(context.Background(), lr)
+}
+
+// Purge discards the segmented log
+func (lr *LineRiver) Purge() error {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+
+	if err := lr.log.Seal(); err != nil {
+		return err
+	}
+	for _, segment := range lr.log.Sealed() {
+		if err := lr.log.Remove(segment); err != nil {
+			return err
 		}
+		lr.gauge.release(segment.Size)
+	}
+	for _, batch := range lr.failed {
+		delete(lr.inflight, batch)
 	}
-	return bs.Publish(nil)
+	lr.failed = nil
+	return nil
 }
 
-// Flow writes a byte slice to file buffer
+// Flow writes a byte slice to the segmented log
 func (lr *LineRiver) Flow(data []byte) {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Print(r)
+			lr.Logger.Errorf("Drop the record: %v", r)
 		}
 	}()
 
-	if _, err := lr.file.Write(data); err != nil {
-		log.Panic(err)
+	data = lr.Processors.Process(data)
+	if data == nil {
+		return
+	}
+
+	sealed, err := lr.Keyring.Seal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	ok, excess := lr.gauge.acquire(int(wal.RecordSize(len(sealed))))
+	if !ok {
+		return
+	}
+	if excess > 0 {
+		lr.evict(excess)
+	}
+	if _, err := lr.log.Append(sealed); err != nil {
+		panic(err)
 	}
+	lr.syncer.mark()
 }
@@ -1,7 +1,10 @@
 package river
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
+	"os"
 	"testing"
 	"time"
 
@@ -33,19 +36,25 @@ func TestLineFlow(t *testing.T) {
 func TestLineConnect(t *testing.T) {
 	<-test.UnixTestServer(lineRiver.SocketPath)
 
-	us := lineRiver.Connect()
+	us, err := lineRiver.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
 	time.Sleep(time.Second * 2)
 	us.Cancel()
 }
 
 func TestLineListen(t *testing.T) {
-	stop := lineRiver.Listen()
+	stop, err := lineRiver.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
 	test.LockUntilReady(lineRiver.SocketPath)
 	test.UnixTestClient(lineRiver.SocketPath)
 
 	consumer := lineRiver.Consume()
 	consumer.Subscribe(func(data []byte) {
-		_ = lineRiver.Push(data)
+		log.Print(string(data))
 		consumer.Cancel()
 	})
 	stop()
@@ -61,3 +70,131 @@ func TestLineConsume(t *testing.T) {
 		consumer.Cancel()
 	})
 }
+
+func TestLineLegacyBuffer(t *testing.T) {
+	legacyPath := "./legacy.tmp"
+	if err := ioutil.WriteFile(legacyPath, []byte("first\nsecond\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(legacyPath)
+
+	lr := NewLineRiver(&Config{
+		BufferPath: legacyPath,
+		Supplyer:   lake.NewConsoleSupplyer(),
+	})
+	defer lr.Close()
+
+	stat, err := lr.Stat()
+	if err != nil || stat.Records != 2 {
+		t.Fatalf("legacy buffer is not imported: %s %v", stat, err)
+	}
+}
+
+func TestLineBatchOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &captureSupplyer{}
+	lr := NewLineRiver(&Config{BufferPath: dir, Supplyer: sink})
+	defer lr.Close()
+
+	for _, record := range []string{"first\n", "second\n", "third\n"} {
+		lr.Flow([]byte(record))
+	}
+	if err := lr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lr.Flow([]byte("fourth\n"))
+	if err := lr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.batches) != 2 {
+		t.Fatalf("unexpected batches: %d", len(sink.batches))
+	}
+	first, second := sink.batches[0], sink.batches[1]
+	if first.Records != 3 || first.FirstOffset != 1 || first.LastOffset != 3 {
+		t.Fatalf("unexpected first batch: %+v", first)
+	}
+	if second.Records != 1 || second.FirstOffset != 4 || second.LastOffset != 4 {
+		t.Fatalf("unexpected second batch: %+v", second)
+	}
+}
+
+func TestLineCommit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &captureSupplyer{}
+	lr := NewLineRiver(&Config{BufferPath: dir, Supplyer: sink})
+	defer lr.Close()
+
+	lr.Flow([]byte("first\n"))
+	first, err := lr.Ready()
+	if err != nil || first == nil {
+		t.Fatalf("unexpected batch %+v: %v", first, err)
+	}
+	lr.Flow([]byte("second\n"))
+	if err := lr.Push([]byte("other\n")); err != nil {
+		t.Fatal(err)
+	}
+	second, err := lr.Ready()
+	if err != nil || second == nil || second.FirstOffset != 2 {
+		t.Fatalf("the batch in flight is taken again %+v: %v", second, err)
+	}
+
+	if err := lr.Commit(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+	if err := lr.Commit(context.Background(), second); err != ErrUnknownBatch {
+		t.Fatalf("unexpected commit of a committed batch: %v", err)
+	}
+	if stat, _ := lr.Stat(); stat.Records != 1 || stat.Oldest != 1 {
+		t.Fatalf("segments of another batch are deleted: %s", stat)
+	}
+	if err := lr.Commit(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := lr.Stat(); stat.Records != 0 {
+		t.Fatalf("segments of the commit are kept: %s", stat)
+	}
+}
+
+func TestLineFailedBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lr := NewLineRiver(&Config{BufferPath: dir, Supplyer: failSupplyer{}})
+	defer lr.Close()
+
+	lr.Flow([]byte("first\n"))
+	failed, _ := lr.Ready()
+	if err := lr.Commit(context.Background(), failed); err == nil {
+		t.Fatal("push failure is not returned")
+	}
+	lr.Flow([]byte("second\n"))
+	if batch, _ := lr.Ready(); batch != failed {
+		t.Fatalf("the failed batch is not taken again as it is: %+v", batch)
+	}
+
+	sink := &captureSupplyer{}
+	lr.Supplyer = sink
+	if err := lr.Commit(context.Background(), failed); err != nil {
+		t.Fatal(err)
+	}
+	if err := lr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if string(sink.data) != "first\nsecond\n" || len(sink.batches) != 2 {
+		t.Fatalf("unexpected pushes: %q", sink.data)
+	}
+}
@@ -0,0 +1,314 @@
+package river
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/findcoo/s4/input"
+	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/stream"
+)
+
+// defaultMemorySize caps the MemoryRiver when the MaxBufferSize is not given
+const defaultMemorySize = 64 << 20
+
+// MemoryRiver buffers the records in a ring of the memory, the records are lost with the process
+type MemoryRiver struct {
+	// ring the records from the offset first, a committed record is nil until the older ones are committed
+	ring  [][]byte
+	head  int
+	count int
+	first uint64
+	// inflight the batches taken by Ready until they are committed
+	inflight map[*lake.Batch]bool
+	// failed the batches in flight whose push failed, Ready takes them again first
+	failed []*lake.Batch
+	mutex  *sync.Mutex
+	gauge  *gauge
+	*Config
+}
+
+// NewMemoryRiver returns a MemoryRiver, it exits the process on a failure
+func NewMemoryRiver(config *Config) *MemoryRiver {
+	mr, err := OpenMemoryRiver(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return mr
+}
+
+// OpenMemoryRiver returns a MemoryRiver, the MaxBufferSize caps the bytes of the ring
+// and the oldest records are overwritten when the OverflowPolicy is not given
+func OpenMemoryRiver(config *Config) (*MemoryRiver, error) {
+	config.Logger.Infof("Create the Memory-river")
+	if config.MaxBufferSize <= 0 {
+		config.MaxBufferSize = defaultMemorySize
+	}
+	if config.OverflowPolicy == "" {
+		config.OverflowPolicy = OverflowDropOldest
+	}
+
+	g, err := newGauge(config.Logger, config.MaxBufferSize, 0, config.OverflowPolicy)
+	if err != nil {
+		return nil, err
+	}
+	mr := &MemoryRiver{
+		ring:     make([][]byte, 16),
+		first:    1,
+		inflight: make(map[*lake.Batch]bool),
+		mutex:    &sync.Mutex{},
+		gauge:    g,
+		Config:   config,
+	}
+	return mr, nil
+}
+
+// Connect wrapping the accept
+func (mr *MemoryRiver) Connect() (*input.UnixSocket, error) {
+	return connect(mr.Config, mr.Flow)
+}
+
+// Listen wrapping the listen
+func (mr *MemoryRiver) Listen() (func(), error) {
+	return listen(mr.Config, mr.Flow)
+}
+
+// Consume returns the *stream.BytesStream, the records of a batch are deleted once it is pushed,
+// the ring is flushed on the cancel even if the KeepBuffer is set since it does not outlive the river
+func (mr *MemoryRiver) Consume() *stream.BytesStream {
+	flush := func() {
+		if err := mr.Flush(); err != nil {
+			mr.Logger.Errorf("Flush the buffer: %v", err)
+		}
+	}
+	return consume(mr, mr.Config, flush)
+}
+
+// Close releases the ring
+func (mr *MemoryRiver) Close() error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	mr.reset()
+	return nil
+}
+
+// at returns the i-th record from the oldest
+func (mr *MemoryRiver) at(i int) []byte {
+	return mr.ring[(mr.head+i)%len(mr.ring)]
+}
+
+// push appends a record to the ring, the ring grows when it is full of records
+func (mr *MemoryRiver) push(record []byte) {
+	if mr.count == len(mr.ring) {
+		ring := make([][]byte, len(mr.ring)*2)
+		for i := 0; i < mr.count; i++ {
+			ring[i] = mr.at(i)
+		}
+		mr.ring, mr.head = ring, 0
+	}
+	mr.ring[(mr.head+mr.count)%len(mr.ring)] = record
+	mr.count++
+}
+
+// pop removes the oldest record of the ring
+func (mr *MemoryRiver) pop() []byte {
+	record := mr.ring[mr.head]
+	mr.ring[mr.head] = nil
+	mr.head = (mr.head + 1) % len(mr.ring)
+	mr.count--
+	mr.first++
+	return record
+}
+
+// reset empties the ring and frees the space of the buffer
+func (mr *MemoryRiver) reset() {
+	var size int64
+	for mr.count > 0 {
+		size += int64(len(mr.pop()))
+	}
+	mr.gauge.release(size)
+}
+
+// Ready takes the records of the ring that are not in flight as a batch, nil when there is none,
+// the records are in flight until the batch is committed
+func (mr *MemoryRiver) Ready() (*lake.Batch, error) {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	// a failed batch is taken again as it is so that the objects it put are not put again
+	if len(mr.failed) > 0 {
+		batch := mr.failed[0]
+		mr.failed = mr.failed[1:]
+		return batch, nil
+	}
+
+	var data []byte
+	var firstOffset, lastOffset uint64
+	for i := 0; i < mr.count; i++ {
+		record, offset := mr.at(i), mr.first+uint64(i)
+		if record == nil {
+			continue
+		}
+		if mr.isInflight(offset) {
+			// the offsets of a batch are contiguous
+			if data != nil {
+				break
+			}
+			continue
+		}
+		if data == nil {
+			firstOffset = offset
+		}
+		data = append(data, record...)
+		lastOffset = offset
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	batch := lake.NewBatch(data)
+	batch.FirstOffset, batch.LastOffset = firstOffset, lastOffset
+	mr.inflight[batch] = true
+	return batch, nil
+}
+
+// Commit pushes the batch taken by Ready out of the lock of the ring
+// and deletes its records after the push succeeds
+func (mr *MemoryRiver) Commit(ctx context.Context, batch *lake.Batch) error {
+	err := lake.PushBatch(ctx, mr.Supplyer, batch)
+	if rerr := mr.release(batch, err == nil); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// release ends the flight of the batch, the records of the acknowledged batch are deleted,
+// the records evicted or purged in the meantime are already gone
+func (mr *MemoryRiver) release(batch *lake.Batch, ack bool) error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	if !mr.inflight[batch] {
+		return ErrUnknownBatch
+	}
+	if !ack {
+		mr.failed = append(mr.failed, batch)
+		return nil
+	}
+	delete(mr.inflight, batch)
+	mr.failed = dropBatch(mr.failed, batch)
+
+	offset := batch.FirstOffset
+	if offset < mr.first {
+		offset = mr.first
+	}
+	var size int64
+	for ; offset <= batch.LastOffset && offset < mr.first+uint64(mr.count); offset++ {
+		i := (mr.head + int(offset-mr.first)) % len(mr.ring)
+		size += int64(len(mr.ring[i]))
+		mr.ring[i] = nil
+	}
+	for mr.count > 0 && mr.ring[mr.head] == nil {
+		mr.pop()
+	}
+	mr.gauge.release(size)
+	return nil
+}
+
+// isInflight reports whether the record of the offset belongs to a batch in flight
+func (mr *MemoryRiver) isInflight(offset uint64) bool {
+	for batch := range mr.inflight {
+		if offset >= batch.FirstOffset && offset <= batch.LastOffset {
+			return true
+		}
+	}
+	return false
+}
+
+// evict removes the oldest records until the excess bytes are freed
+func (mr *MemoryRiver) evict(excess int64) {
+	var freed int64
+	var records int
+	for freed < excess && mr.count > 0 {
+		if record := mr.pop(); record != nil {
+			freed += int64(len(record))
+			records++
+		}
+	}
+	mr.gauge.evicted(records, freed)
+}
+
+// Stat returns the statistics of the ring
+func (mr *MemoryRiver) Stat() (*Stat, error) {
+	return statOf(mr)
+}
+
+// Walk calls fn with each record of the ring in order of the offset
+func (mr *MemoryRiver) Walk(fn func(offset uint64, record []byte) error) error {
+	mr.mutex.Lock()
+	records := make([][]byte, mr.count)
+	for i := range records {
+		records[i] = mr.at(i)
+	}
+	first := mr.first
+	mr.mutex.Unlock()
+
+	for i, record := range records {
+		if record == nil {
+			continue
+		}
+		if err := fn(first+uint64(i), record); err != nil {
+			if err == ErrStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush pushes the records that are not in flight to the Supplyer, the records are kept when the push fails
+func (mr *MemoryRiver) Flush() error {
+	return FlushContext(context.Background(), mr)
+}
+
+// Purge discards the ring
+func (mr *MemoryRiver) Purge() error {
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	mr.reset()
+	for _, batch := range mr.failed {
+		delete(mr.inflight, batch)
+	}
+	mr.failed = nil
+	return nil
+}
+
+// Flow writes a byte slice to the ring
+func (mr *MemoryRiver) Flow(data []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			mr.Logger.Errorf("Drop the record: %v", r)
+		}
+	}()
+
+	// an empty record would be taken for a committed one
+	data = mr.Processors.Process(data)
+	if len(data) == 0 {
+		return
+	}
+
+	ok, excess := mr.gauge.acquire(len(data))
+	if !ok {
+		return
+	}
+
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+	if excess > 0 {
+		mr.evict(excess)
+	}
+	mr.push(append([]byte(nil), data...))
+}
@@ -0,0 +1,151 @@
+package river
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type failSupplyer struct{}
+
+func (failSupplyer) Push([]byte) error {
+	return errors.New("push failed")
+}
+
+func TestMemoryRing(t *testing.T) {
+	mr := NewMemoryRiver(&Config{MaxBufferSize: 30})
+	defer mr.Close()
+
+	for _, record := range []string{"first 1\n", "second 2\n", "third 03\n", "fourth 4\n"} {
+		mr.Flow([]byte(record))
+	}
+	stat, err := mr.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Records != 3 || stat.Oldest != 2 || stat.Newest != 4 || stat.Bytes != 27 {
+		t.Fatalf("oldest record is not overwritten: %s", stat)
+	}
+
+	for i := 0; i < 100; i++ {
+		mr.Flow([]byte("ring\n"))
+	}
+	if stat, _ := mr.Stat(); stat.Records != 6 || stat.Newest != 104 {
+		t.Fatalf("unexpected ring: %s", stat)
+	}
+
+	if batch, _ := mr.Ready(); batch == nil || string(batch.Data) != "ring\nring\nring\nring\nring\nring\n" {
+		t.Fatalf("unexpected batch: %+v", batch)
+	}
+}
+
+func TestMemoryFlushFailure(t *testing.T) {
+	mr := NewMemoryRiver(&Config{Supplyer: failSupplyer{}})
+	defer mr.Close()
+
+	mr.Flow([]byte("kept\n"))
+	if err := mr.Flush(); err == nil {
+		t.Fatal("push failure is not returned")
+	}
+	if stat, _ := mr.Stat(); stat.Records != 1 {
+		t.Fatalf("records are lost by the failed push: %s", stat)
+	}
+}
+
+type blockSupplyer struct {
+	release chan struct{}
+}
+
+func (bs blockSupplyer) Push([]byte) error {
+	<-bs.release
+	return nil
+}
+
+func TestMemoryCommit(t *testing.T) {
+	supplyer := blockSupplyer{release: make(chan struct{})}
+	mr := NewMemoryRiver(&Config{Supplyer: supplyer})
+	defer mr.Close()
+
+	mr.Flow([]byte("first\n"))
+	mr.Flow([]byte("second\n"))
+	first, _ := mr.Ready()
+	if first == nil || first.FirstOffset != 1 || first.LastOffset != 2 {
+		t.Fatalf("unexpected batch: %+v", first)
+	}
+
+	committed := make(chan error)
+	go func() {
+		committed <- mr.Commit(context.Background(), first)
+	}()
+	flowed := make(chan struct{})
+	go func() {
+		mr.Flow([]byte("third\n"))
+		close(flowed)
+	}()
+	select {
+	case <-flowed:
+	case <-time.After(time.Second):
+		t.Fatal("the flow is blocked by the push")
+	}
+
+	second, _ := mr.Ready()
+	if second == nil || second.FirstOffset != 3 || string(second.Data) != "third\n" {
+		t.Fatalf("the batch in flight is taken again: %+v", second)
+	}
+	close(supplyer.release)
+	if err := <-committed; err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := mr.Stat(); stat.Records != 1 || stat.Oldest != 3 {
+		t.Fatalf("unexpected records after the commit: %s", stat)
+	}
+	if err := mr.Commit(context.Background(), second); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := mr.Stat(); stat.Records != 0 {
+		t.Fatalf("records of the commit are kept: %s", stat)
+	}
+}
+
+func TestMemoryListenStop(t *testing.T) {
+	mr := NewMemoryRiver(&Config{SocketPath: "./memory.sock"})
+	defer mr.Close()
+
+	stop, err := mr.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", mr.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := conn.Write([]byte("record\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for stat, _ := mr.Stat(); stat.Records == 0; stat, _ = mr.Stat() {
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	if stat, _ := mr.Stat(); stat.Records != 100 {
+		t.Fatalf("records of the accepted connection are lost by the stop: %s", stat)
+	}
+}
+
+func TestMemoryConsumeFailure(t *testing.T) {
+	mr := NewMemoryRiver(&Config{Supplyer: failSupplyer{}, FlushIntervalTime: time.Millisecond * 10})
+	defer mr.Close()
+
+	mr.Flow([]byte("kept\n"))
+	consumer := mr.Consume()
+	consumer.Subscribe(func(data []byte) {
+		consumer.Cancel()
+	})
+	if stat, _ := mr.Stat(); stat.Records != 1 {
+		t.Fatalf("records of the consumed batch are lost by the failed push: %s", stat)
+	}
+}
@@ -0,0 +1,112 @@
+package river
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/findcoo/s4/crypt"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/wal"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// quarantineDir returns the side directory of the unreadable files of the buffer
+func quarantineDir(bufferPath string) string {
+	return path.Clean(bufferPath) + ".quarantine"
+}
+
+// quarantine moves the whole buffer to the side directory so that a fresh buffer can be created
+func quarantine(bufferPath string) (string, error) {
+	dir := quarantineDir(bufferPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	moved := path.Join(dir, fmt.Sprintf("%s-%d", path.Base(bufferPath), time.Now().Unix()))
+	return moved, os.Rename(bufferPath, moved)
+}
+
+// recoverLog quarantines the corrupted segments of the log
+func recoverLog(log *logger.Entry, bufferPath string, wl *wal.Log) error {
+	recoveries, err := wl.Recover(quarantineDir(bufferPath))
+	if err != nil {
+		return err
+	}
+	for _, r := range recoveries {
+		log.Warnf("Quarantine the corrupted segment %s to %s, %d records salvaged", r.Segment.Path, r.Quarantine, r.Salvaged)
+	}
+	return nil
+}
+
+// openLevelDB opens the levelDB of the buffer, a corrupted levelDB is recovered
+// or quarantined when it cannot be recovered
+func openLevelDB(log *logger.Entry, bufferPath string, options *opt.Options) (*leveldb.DB, error) {
+	ldb, err := leveldb.OpenFile(bufferPath, options)
+	if err == nil || !errors.IsCorrupted(err) {
+		return ldb, err
+	}
+
+	log.Warnf("Recover the corrupted buffer %s: %v", bufferPath, err)
+	if ldb, err = leveldb.RecoverFile(bufferPath, options); err == nil {
+		return ldb, nil
+	}
+	return freshLevelDB(log, bufferPath, options, err)
+}
+
+// freshLevelDB quarantines the levelDB and creates an empty one
+func freshLevelDB(log *logger.Entry, bufferPath string, options *opt.Options, cause error) (*leveldb.DB, error) {
+	moved, err := quarantine(bufferPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Warnf("Quarantine the unrecoverable buffer %s to %s: %v, continue with a fresh buffer", bufferPath, moved, cause)
+	return leveldb.OpenFile(bufferPath, options)
+}
+
+// migrateLevelDB rewrites the unpadded offset keys of the previous version into the fixed width form
+// of offsetKey, LevelDB orders the unpadded keys as strings and loses the order of the offsets
+func migrateLevelDB(ldb *leveldb.DB) (int, error) {
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) == len(offsetKey(0)) {
+			continue
+		}
+		offset, err := strconv.ParseUint(string(key), 10, 64)
+		if err != nil {
+			continue
+		}
+		batch.Put(offsetKey(offset), append([]byte(nil), iter.Value()...))
+		batch.Delete(append([]byte(nil), key...))
+	}
+	if err := iter.Error(); err != nil || batch.Len() == 0 {
+		return 0, err
+	}
+	return batch.Len() / 2, ldb.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// scanLevelDB returns the records, the bytes and the last offset of the levelDB,
+// it fails with the first record that the keyring cannot open
+func scanLevelDB(ldb *leveldb.DB, keyring *crypt.Keyring) (records int, size int64, offset uint64, err error) {
+	iter := ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if err := keyring.Check(iter.Value()); err != nil {
+			return 0, 0, 0, err
+		}
+		records++
+		size += int64(len(iter.Value()))
+	}
+	if iter.Last() {
+		offset, _ = strconv.ParseUint(string(iter.Key()), 10, 64)
+	}
+	return records, size, offset, iter.Error()
+}
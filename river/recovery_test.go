@@ -0,0 +1,114 @@
+package river
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func corruptFiles(t *testing.T, dir, prefix string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if len(info.Name()) < len(prefix) || info.Name()[:len(prefix)] != prefix {
+			continue
+		}
+		name := path.Join(dir, info.Name())
+		if err := ioutil.WriteFile(name, []byte("corrupted corrupted corrupted"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJSONRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-recovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bufferPath := path.Join(dir, "json")
+	jr := NewJSONRiver(&Config{BufferPath: bufferPath})
+	jr.Flow([]byte(`{"message": "salvaged"}` + "\n"))
+	_ = jr.Close()
+	corruptFiles(t, bufferPath, "MANIFEST")
+
+	jr = NewJSONRiver(&Config{BufferPath: bufferPath})
+	defer jr.Close()
+	jr.Flow([]byte(`{"message": "fresh"}` + "\n"))
+	if stat, err := jr.Stat(); err != nil || stat.Records != 2 || stat.Newest != 2 {
+		t.Fatalf("buffer is not recovered: %v %v", stat, err)
+	}
+}
+
+func TestJSONMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-migration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldb, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := uint64(1); offset <= 12; offset++ {
+		if err := ldb.Put([]byte(strconv.FormatUint(offset, 10)), []byte(fmt.Sprintf(`{"offset": %d}`+"\n", offset)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = ldb.Close()
+
+	sink := &captureSupplyer{}
+	jr := NewJSONRiver(&Config{BufferPath: dir, Supplyer: sink})
+	defer jr.Close()
+	jr.Flow([]byte(`{"offset": 13}` + "\n"))
+	batch, err := jr.Ready()
+	if err != nil || batch == nil || batch.FirstOffset != 1 || batch.LastOffset != 13 {
+		t.Fatalf("legacy keys are not migrated %+v: %v", batch, err)
+	}
+	if err := jr.Commit(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if stat, _ := jr.Stat(); stat.Records != 0 {
+		t.Fatalf("legacy records are left in the buffer: %s", stat)
+	}
+	for i, line := range strings.Split(strings.TrimSpace(string(sink.batches[0].Data)), "\n") {
+		if line != fmt.Sprintf(`{"offset": %d}`, i+1) {
+			t.Fatalf("legacy records are out of order: %q", sink.batches[0].Data)
+		}
+	}
+}
+
+func TestLineRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-recovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bufferPath := path.Join(dir, "line")
+	lr := NewLineRiver(&Config{BufferPath: bufferPath})
+	lr.Flow([]byte("lost\n"))
+	_ = lr.Close()
+	corruptFiles(t, bufferPath, "0")
+
+	lr = NewLineRiver(&Config{BufferPath: bufferPath})
+	defer lr.Close()
+	if stat, err := lr.Stat(); err != nil || stat.Records != 0 {
+		t.Fatalf("corrupted segment is not quarantined: %v %v", stat, err)
+	}
+	infos, err := ioutil.ReadDir(quarantineDir(bufferPath))
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("unexpected quarantine: %v %v", infos, err)
+	}
+}
@@ -1,59 +1,216 @@
 package river
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/findcoo/s4/crypt"
 	"github.com/findcoo/s4/input"
 	"github.com/findcoo/s4/lake"
+	"github.com/findcoo/s4/logger"
+	"github.com/findcoo/s4/process"
 	"github.com/findcoo/stream"
 )
 
+// river types
+const (
+	TypeLine   = "line"
+	TypeJSON   = "json"
+	TypeMemory = "memory"
+)
+
+var (
+	// ErrUnknownRiver unknown river type
+	ErrUnknownRiver = errors.New("unknown river type")
+	// ErrUnknownBatch the batch is not taken from the river or it is already committed
+	ErrUnknownBatch = errors.New("unknown batch of the river")
+)
+
 // River meaning temporary data-stream flow to the data-lake
 type River interface {
-	Connect() *input.UnixSocket
-	Listen() func()
+	Connect() (*input.UnixSocket, error)
+	Listen() (func(), error)
 	Consume() *stream.BytesStream
+	// Ready takes the buffered records that are not in flight as a batch, nil when there is none
+	Ready() (*lake.Batch, error)
+	// Commit pushes the batch and deletes its records after the push succeeds,
+	// the records are taken again by a later Ready when it fails or the context is done
+	Commit(ctx context.Context, batch *lake.Batch) error
 	Flow(data []byte)
+	Close() error
+	Buffer
 	lake.Supplyer
 }
 
+// NewRiver returns the river of the type
+func NewRiver(rivertype string, config *Config) (River, error) {
+	switch rivertype {
+	case TypeLine:
+		return OpenLineRiver(config)
+	case TypeJSON:
+		return OpenJSONRiver(config)
+	case TypeMemory:
+		return OpenMemoryRiver(config)
+	}
+	return nil, ErrUnknownRiver
+}
+
 // Config ...
 type Config struct {
 	BufferPath        string
 	SocketPath        string
 	FlushIntervalTime time.Duration
+	MaxBufferSize     int64
+	SegmentSize       int64
+	SegmentAge        time.Duration
+	Durability        string
+	SyncInterval      time.Duration
+	OverflowPolicy    string
+	// Keyring encrypts the records of the file buffers, nil keeps them in plain text
+	Keyring    *crypt.Keyring
+	Processors process.Chain
+	// ConnProcessors returns the processors of the connection of the id
+	ConnProcessors func(conn uint64) process.Chain
+	// KeepBuffer skips the flush of the buffer when the consumer is canceled
+	KeepBuffer bool
+	// Logger logs with the fields of the pipeline, nil logs without fields
+	Logger *logger.Entry
 	lake.Supplyer
 }
 
-func connect(sockpath string, flowFunc func([]byte)) *input.UnixSocket {
-	log.Print("Connect to the waterhead")
-	us := input.ConnectUnixSocket(sockpath)
+// connFlow wraps the flowFunc with the processors of a new connection
+func connFlow(newChain func(conn uint64) process.Chain, us *input.UnixSocket, flowFunc func([]byte)) (func([]byte), process.Chain) {
+	if newChain == nil {
+		return flowFunc, nil
+	}
 
-	go us.Publish().Subscribe(func(data []byte) {
-		flowFunc(data)
-	})
-	return us
+	chain := newChain(us.ID)
+	flow := func(data []byte) {
+		if data = chain.Process(data); data != nil {
+			flowFunc(data)
+		}
+	}
+	return flow, chain
 }
 
-func listen(sockpath string, flowFunc func([]byte)) func() {
-	log.Print("Listenning")
-	streams, stop := input.ListenUnixSocket(sockpath)
+func connect(config *Config, flowFunc func([]byte)) (*input.UnixSocket, error) {
+	us, err := input.DialUnixSocket(config.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger.With("input", config.SocketPath, "conn", us.ID).Infof("Connect to the waterhead")
+	flow, chain := connFlow(config.ConnProcessors, us, flowFunc)
+
+	published := us.Publish()
 	go func() {
+		published.Subscribe(func(data []byte) {
+			flow(data)
+		})
+		chain.Report()
+	}()
+	return us, nil
+}
+
+func listen(config *Config, flowFunc func([]byte)) (func(), error) {
+	streams, stop, err := input.ServeUnixSocket(config.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger.With("input", config.SocketPath).Infof("Listen the waterhead")
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
 		for us := range streams {
+			flow, chain := connFlow(config.ConnProcessors, us, flowFunc)
 			us.Publish().Subscribe(func(data []byte) {
-				flowFunc(data)
+				flow(data)
 			})
+			chain.Report()
 		}
 	}()
-	return stop
+	// the records of the accepted connections flow into the river before the stop returns
+	return func() {
+		stop()
+		<-ended
+	}, nil
 }
 
-func readyConsume(flush func(), flushtime time.Duration) (*stream.BytesStream, *time.Ticker) {
-	log.Print("Consume the flow")
+// unseal decrypts a record of the buffer, the record that cannot be decrypted fails the read
+// and stays in the buffer
+func unseal(keyring *crypt.Keyring, offset uint64, record []byte) ([]byte, error) {
+	opened, err := keyring.Open(record)
+	if err != nil {
+		return nil, fmt.Errorf("record %d of the buffer: %v", offset, err)
+	}
+	return opened, nil
+}
+
+// checkKeys fails with the first record that the keyring cannot open,
+// a buffer sealed with a key that is not configured is not opened
+func checkKeys(keyring *crypt.Keyring, walk func(fn func(offset uint64, record []byte) error) error) error {
+	return walk(func(offset uint64, record []byte) error {
+		return keyring.Check(record)
+	})
+}
+
+// dropBatch removes the batch from the batches
+func dropBatch(batches []*lake.Batch, batch *lake.Batch) []*lake.Batch {
+	for i, b := range batches {
+		if b == batch {
+			return append(batches[:i], batches[i+1:]...)
+		}
+	}
+	return batches
+}
+
+// FlushContext commits the batches of the river until the records that are not in flight are pushed,
+// the context cancels the pushes
+func FlushContext(ctx context.Context, r River) error {
+	for {
+		batch, err := r.Ready()
+		if err != nil || batch == nil {
+			return err
+		}
+		if err := r.Commit(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// consume sends the batches of the river to the stream and commits them, the records of a batch
+// are deleted only after its push succeeds
+func consume(r River, config *Config, flush func()) *stream.BytesStream {
+	config.Logger.Infof("Consume the flow")
 	bs := stream.NewBytesStream(stream.NewObserver(nil))
-	ticker := time.NewTicker(flushtime)
+	ticker := time.NewTicker(config.FlushIntervalTime)
 
 	bs.Handler.AtCancel = flush
-	return bs, ticker
+	bs.Target = func() {
+		defer ticker.Stop()
+	PubLoop:
+		for {
+			select {
+			case <-bs.AfterCancel():
+				break PubLoop
+			case <-ticker.C:
+				batch, err := r.Ready()
+				if err != nil {
+					config.Logger.Errorf("Read the buffer: %v", err)
+					continue
+				}
+				if batch == nil {
+					continue
+				}
+
+				bs.Send(batch.Data)
+				if err := r.Commit(context.Background(), batch); err != nil {
+					config.Logger.Warnf("Keep the batch in the buffer: %v", err)
+				}
+				config.Processors.Report()
+			}
+		}
+	}
+	return bs.Publish(nil)
 }
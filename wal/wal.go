@@ -0,0 +1,450 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/findcoo/s4/logger"
+)
+
+const (
+	segmentExt = ".wal"
+	headerSize = 16
+	// nextFile keeps the next offset so that the offsets of an emptied log do not restart
+	nextFile = "next"
+)
+
+var (
+	// ErrCorrupted the checksum of a record does not match
+	ErrCorrupted = errors.New("corrupted record")
+	// ErrClosed the log is closed
+	ErrClosed = errors.New("log is closed")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options of the log
+type Options struct {
+	// SegmentSize rolls the active segment over the size, zero is unlimited
+	SegmentSize int64
+	// SegmentAge rolls the active segment older than the age, zero is unlimited
+	SegmentAge time.Duration
+	// SyncAppend fsyncs every append, sealed segments are always fsynced
+	SyncAppend bool
+}
+
+// Recovery of a corrupted segment
+type Recovery struct {
+	Segment Segment
+	// Salvaged records before the corruption that are kept in the log
+	Salvaged int
+	// Quarantine path of the original segment
+	Quarantine string
+}
+
+// Segment a sealed segment of the log
+type Segment struct {
+	// First offset of the first record
+	First uint64
+	Path  string
+	Size  int64
+}
+
+// Log append-only log split into segments,
+// each record is framed with its length, checksum and offset
+type Log struct {
+	dir     string
+	opts    Options
+	mutex   *sync.Mutex
+	active  *os.File
+	first   uint64
+	size    int64
+	created time.Time
+	next    uint64
+	sealed  []Segment
+}
+
+// RecordSize returns the bytes that a record of n bytes takes in a segment
+func RecordSize(n int) int64 {
+	return int64(headerSize + n)
+}
+
+// Size returns the bytes of the sealed and the active segments
+func (l *Log) Size() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	size := l.size
+	for _, s := range l.sealed {
+		size += s.Size
+	}
+	return size
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%020d%s", first, segmentExt)
+}
+
+// Open opens the log of the directory, the segments left by the previous process are sealed,
+// the offsets resume after the last record or the next offset kept by the emptied log
+func Open(dir string, opts Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:   dir,
+		opts:  opts,
+		mutex: &sync.Mutex{},
+		next:  1,
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		if info.Size() == 0 {
+			_ = os.Remove(path.Join(dir, name))
+			continue
+		}
+		l.sealed = append(l.sealed, Segment{
+			First: first,
+			Path:  path.Join(dir, name),
+			Size:  info.Size(),
+		})
+	}
+	sort.Slice(l.sealed, func(i, j int) bool {
+		return l.sealed[i].First < l.sealed[j].First
+	})
+
+	if raw, err := ioutil.ReadFile(path.Join(dir, nextFile)); err == nil {
+		if next, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64); err == nil && next > l.next {
+			l.next = next
+		}
+	}
+	if n := len(l.sealed); n > 0 {
+		last := l.sealed[n-1]
+		if last.First > l.next {
+			l.next = last.First
+		}
+		err := last.Walk(func(offset uint64, data []byte) error {
+			if offset >= l.next {
+				l.next = offset + 1
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Warnf("%s: %v", last.Path, err)
+		}
+	}
+	return l, nil
+}
+
+// Append writes a record to the active segment and returns its offset
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.dir == "" {
+		return 0, ErrClosed
+	}
+	if l.active != nil && l.expired() {
+		if err := l.seal(); err != nil {
+			return 0, err
+		}
+	}
+	if l.active == nil {
+		f, err := os.OpenFile(path.Join(l.dir, segmentName(l.next)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return 0, err
+		}
+		l.active, l.first, l.size, l.created = f, l.next, 0, time.Now()
+	}
+
+	offset := l.next
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint64(record[8:16], offset)
+	copy(record[headerSize:], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(record[8:], castagnoli))
+
+	if _, err := l.active.Write(record); err != nil {
+		return 0, err
+	}
+	if l.opts.SyncAppend {
+		if err := l.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	l.size += int64(len(record))
+	l.next++
+	return offset, nil
+}
+
+func (l *Log) expired() bool {
+	if l.opts.SegmentSize > 0 && l.size >= l.opts.SegmentSize {
+		return true
+	}
+	return l.opts.SegmentAge > 0 && time.Since(l.created) >= l.opts.SegmentAge
+}
+
+// Sync fsyncs the active segment
+func (l *Log) Sync() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	return l.active.Sync()
+}
+
+// Seal rolls the active segment so that it can be read and removed
+func (l *Log) Seal() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.seal()
+}
+
+func (l *Log) seal() error {
+	if l.active == nil {
+		return nil
+	}
+	if err := l.active.Sync(); err != nil {
+		return err
+	}
+	if err := l.active.Close(); err != nil {
+		return err
+	}
+
+	l.sealed = append(l.sealed, Segment{
+		First: l.first,
+		Path:  l.active.Name(),
+		Size:  l.size,
+	})
+	l.active = nil
+	return nil
+}
+
+// Sealed returns the sealed segments in order of the offset
+func (l *Log) Sealed() []Segment {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Segment(nil), l.sealed...)
+}
+
+// Remove deletes a sealed segment
+func (l *Log) Remove(segment Segment) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i, s := range l.sealed {
+		if s.First == segment.First {
+			l.sealed = append(l.sealed[:i], l.sealed[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(segment.Path); err != nil {
+		return err
+	}
+	if len(l.sealed) == 0 && l.active == nil {
+		return l.writeNext()
+	}
+	return nil
+}
+
+// writeNext keeps the next offset in the directory, the file is replaced atomically
+func (l *Log) writeNext() error {
+	name := path.Join(l.dir, nextFile)
+	f, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strconv.FormatUint(l.next, 10)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// Recover checks the sealed segments, a corrupted segment is moved to the quarantine directory
+// and replaced by the records before the corruption
+func (l *Log) Recover(quarantine string) ([]Recovery, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var recoveries []Recovery
+	sealed := l.sealed[:0]
+	for _, segment := range l.sealed {
+		var valid int64
+		var records int
+		err := segment.Walk(func(offset uint64, data []byte) error {
+			valid += RecordSize(len(data))
+			records++
+			return nil
+		})
+		if err != ErrCorrupted {
+			sealed = append(sealed, segment)
+			continue
+		}
+
+		recovery, err := segment.quarantine(quarantine, valid)
+		if err != nil {
+			return recoveries, err
+		}
+		recovery.Salvaged = records
+		recoveries = append(recoveries, recovery)
+		if valid > 0 {
+			segment.Size = valid
+			sealed = append(sealed, segment)
+		}
+	}
+	l.sealed = sealed
+	return recoveries, nil
+}
+
+// quarantine moves the segment to the dir and writes back the valid bytes of it,
+// the time suffix keeps the earlier quarantines of a reused segment name
+func (s Segment) quarantine(dir string, valid int64) (Recovery, error) {
+	name := fmt.Sprintf("%s-%d", path.Base(s.Path), time.Now().Unix())
+	recovery := Recovery{Segment: s, Quarantine: path.Join(dir, name)}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return recovery, err
+	}
+	if err := os.Rename(s.Path, recovery.Quarantine); err != nil {
+		return recovery, err
+	}
+	if valid == 0 {
+		return recovery, nil
+	}
+
+	raw, err := ioutil.ReadFile(recovery.Quarantine)
+	if err != nil {
+		return recovery, err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return recovery, err
+	}
+	defer f.Close()
+	if _, err := f.Write(raw[:valid]); err != nil {
+		return recovery, err
+	}
+	return recovery, f.Sync()
+}
+
+// Walk calls fn with each record of the sealed and the active segments
+func (l *Log) Walk(fn func(offset uint64, data []byte) error) error {
+	l.mutex.Lock()
+	segments := append([]Segment(nil), l.sealed...)
+	if l.active != nil {
+		segments = append(segments, Segment{First: l.first, Path: l.active.Name(), Size: l.size})
+	}
+	l.mutex.Unlock()
+
+	for _, segment := range segments {
+		if err := segment.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close seals the active segment and closes the log
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	err := l.seal()
+	if err == nil && l.dir != "" {
+		err = l.writeNext()
+	}
+	l.dir = ""
+	return err
+}
+
+// Walk calls fn with each record of the segment,
+// it stops with ErrCorrupted at a record whose checksum does not match
+func (s Segment) Walk(fn func(offset uint64, data []byte) error) error {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+	remaining := info.Size()
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return ErrCorrupted
+		}
+
+		// a length beyond the end of the segment is a torn or corrupted header, it is not allocated
+		length := binary.BigEndian.Uint32(header[0:4])
+		remaining -= headerSize + int64(length)
+		if remaining < 0 {
+			return ErrCorrupted
+		}
+		record := make([]byte, 8+length)
+		copy(record, header[8:16])
+		if _, err := io.ReadFull(reader, record[8:]); err != nil {
+			return ErrCorrupted
+		}
+		if crc32.Checksum(record, castagnoli) != binary.BigEndian.Uint32(header[4:8]) {
+			return ErrCorrupted
+		}
+
+		if err := fn(binary.BigEndian.Uint64(header[8:16]), record[8:]); err != nil {
+			return err
+		}
+	}
+}
+
+// Read returns the concatenated records of the segment, the records after a corruption are skipped
+func (s Segment) Read() ([]byte, error) {
+	var data []byte
+	err := s.Walk(func(offset uint64, record []byte) error {
+		data = append(data, record...)
+		return nil
+	})
+	if err == ErrCorrupted {
+		logger.Warnf("%s: %v, the rest of the segment is skipped", s.Path, err)
+		err = nil
+	}
+	return data, err
+}
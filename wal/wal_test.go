@@ -0,0 +1,212 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{SegmentSize: RecordSize(6) * 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := l.Append([]byte(fmt.Sprintf("line%d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(l.Sealed()); n != 3 {
+		t.Fatalf("unexpected sealed segments: %d", n)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	offset, err := reopened.Append([]byte("line10\n"))
+	if err != nil || offset != 11 {
+		t.Fatalf("offset is not resumed: %d %v", offset, err)
+	}
+
+	var expected uint64 = 1
+	err = reopened.Walk(func(offset uint64, data []byte) error {
+		if offset != expected {
+			return fmt.Errorf("unexpected offset %d of %s", offset, data)
+		}
+		expected++
+		return nil
+	})
+	if err != nil || expected != 12 {
+		t.Fatalf("walk stopped at %d: %v", expected, err)
+	}
+
+	segments := reopened.Sealed()
+	if err := reopened.Remove(segments[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.Sealed()) != len(segments)-1 {
+		t.Fatal("segment is not removed")
+	}
+}
+
+func TestNextOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = l.Append([]byte("first\n"))
+	_, _ = l.Append([]byte("second\n"))
+	_ = l.Seal()
+	if err := l.Remove(l.Sealed()[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if offset, err := reopened.Append([]byte("third\n")); err != nil || offset != 3 {
+		t.Fatalf("offset of the emptied log restarts: %d %v", offset, err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = l.Append([]byte("first\n"))
+	_, _ = l.Append([]byte("second\n"))
+	_ = l.Seal()
+
+	segment := l.Sealed()[0]
+	raw, _ := ioutil.ReadFile(segment.Path)
+	raw[len(raw)-2] ^= 0xff
+	if err := ioutil.WriteFile(segment.Path, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := segment.Read()
+	if err != nil || string(data) != "first\n" {
+		t.Fatalf("unexpected data %q: %v", data, err)
+	}
+}
+
+func TestTornLength(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = l.Append([]byte("first\n"))
+	_, _ = l.Append([]byte("second\n"))
+	_ = l.Seal()
+	_ = l.Close()
+
+	segment := l.Sealed()[0]
+	raw, _ := ioutil.ReadFile(segment.Path)
+	torn := raw[:RecordSize(6)+headerSize]
+	binary.BigEndian.PutUint32(torn[RecordSize(6):], 0xffffffff)
+	if err := ioutil.WriteFile(segment.Path, torn, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := segment.Read()
+	if err != nil || string(data) != "first\n" {
+		t.Fatalf("unexpected data %q: %v", data, err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s4-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, Options{SegmentSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		_, _ = l.Append([]byte(line))
+	}
+	_ = l.Seal()
+	segments := l.Sealed()
+	_ = l.Close()
+
+	raw, _ := ioutil.ReadFile(segments[1].Path)
+	if err := ioutil.WriteFile(segments[1].Path, append(raw, 0xff, 0xff), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(segments[2].Path, raw[:headerSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	quarantine := dir + ".quarantine"
+	defer os.RemoveAll(quarantine)
+	recoveries, err := reopened.Recover(quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recoveries) != 2 || recoveries[0].Salvaged != 1 || recoveries[1].Salvaged != 0 {
+		t.Fatalf("unexpected recoveries: %+v", recoveries)
+	}
+	if _, err := os.Stat(recoveries[1].Quarantine); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path.Base(recoveries[1].Quarantine), path.Base(segments[2].Path)+"-") {
+		t.Fatalf("the quarantine is not suffixed by the time: %s", recoveries[1].Quarantine)
+	}
+
+	var data []byte
+	if err := reopened.Walk(func(offset uint64, record []byte) error {
+		data = append(data, record...)
+		return nil
+	}); err != nil || string(data) != "first\nsecond\n" {
+		t.Fatalf("unexpected data %q: %v", data, err)
+	}
+	if n := len(reopened.Sealed()); n != 2 {
+		t.Fatalf("unexpected sealed segments: %d", n)
+	}
+}